@@ -2,6 +2,7 @@ package arch
 
 import (
 	"os/exec"
+	"syscall"
 )
 
 func ExtExecution() string {
@@ -15,3 +16,33 @@ func ExtScript() string {
 func CommandExecuteFix(commands ...string) *exec.Cmd {
 	return exec.Command(commands[0], commands[1:]...)
 }
+
+func Reexec(exe string, args []string, env []string) error {
+	return syscall.Exec(exe, args, env)
+}
+
+/// Get free bytes, free inodes and total inodes of file system, total inodes is 0 if not limited
+func DiskFree(path string) (uint64, uint64, uint64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), stat.Ffree, stat.Files, nil
+}
+
+/// Check whether process is still running
+func ProcessAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+/// Run command in its own process group, so that it can be killed with its children by KillTree
+func Detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+/// Kill process group led by pid
+func KillTree(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGKILL)
+}
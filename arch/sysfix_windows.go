@@ -1,9 +1,16 @@
 package arch
 
 import (
+	"os"
 	"os/exec"
+	"strconv"
+	"syscall"
+	"unsafe"
 )
 
+/// Exit code of process still running
+const stillActive = 259
+
 func ExtExecution() string {
 	return ".exe"
 }
@@ -16,3 +23,54 @@ func CommandExecuteFix(commands ...string) *exec.Cmd {
 	cmms := append([]string{"/C"}, commands...)
 	return exec.Command("cmd", cmms...)
 }
+
+func Reexec(exe string, args []string, env []string) error {
+	cmd := exec.Command(exe, args[1:]...)
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
+
+/// Get free bytes, free inodes and total inodes of file system, total inodes is 0 if not limited
+func DiskFree(path string) (uint64, uint64, uint64, error) {
+	kernel32 := syscall.NewLazyDLL("kernel32.dll")
+	getDiskFreeSpaceEx := kernel32.NewProc("GetDiskFreeSpaceExW")
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	var freeBytes uint64
+	ret, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(pathPtr)), uintptr(unsafe.Pointer(&freeBytes)), 0, 0)
+	if ret == 0 {
+		return 0, 0, 0, err
+	}
+	// NTFS has no inode limit
+	return freeBytes, 0, 0, nil
+}
+
+/// Check whether process is still running
+func ProcessAlive(pid int) bool {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	err = syscall.GetExitCodeProcess(h, &code)
+	return err == nil && code == stillActive
+}
+
+/// Run command in its own process group, so that it can be killed with its children by KillTree
+func Detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+/// Kill process and all its children
+func KillTree(pid int) error {
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(pid)).Run()
+}
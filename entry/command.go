@@ -0,0 +1,61 @@
+package entry
+
+import (
+	"flag"
+	"fmt"
+	"iphash-daemon/worker"
+	"log"
+)
+
+var (
+	version = flag.Bool("version", false, "print version of iphash-daemon")
+	status  = flag.Bool("status", false, "print status of the running daemon")
+	hold    = flag.String("hold", "", "hold the daemon at version, \"current\" for the installed version")
+	release = flag.Bool("release", false, "release hold of version")
+	gc      = flag.Bool("gc", false, "remove old packages and report space reclaimed")
+	bundle  = flag.String("install-bundle", "", "verify offline bundle and queue it for installation")
+	watch   = flag.Bool("watch-swap", false, "supervise new iphash-daemon after self-update, used internally")
+)
+
+/// Run command given by flags, returns false if no command given
+func runCommand() bool {
+	switch {
+	case *version:
+		fmt.Println(worker.Version())
+	case *status:
+		err := worker.PrintStatus()
+		if err != nil {
+			log.Fatalln("Unable to read status of the daemon:", err)
+		}
+	case *hold != "":
+		err := worker.Hold(*hold)
+		if err != nil {
+			log.Fatalln("Unable to hold version:", err)
+		}
+		log.Println("Upgrade is held at version", *hold)
+	case *release:
+		err := worker.ReleaseHold()
+		if err != nil {
+			log.Fatalln("Unable to release hold:", err)
+		}
+		log.Println("Hold of version released")
+	case *gc:
+		err := worker.CollectGarbage()
+		if err != nil {
+			log.Fatalln("Unable to remove old packages:", err)
+		}
+	case *bundle != "":
+		err := worker.InstallBundle(*bundle)
+		if err != nil {
+			log.Fatalln("Unable to install bundle:", err)
+		}
+	case *watch:
+		err := worker.WatchDaemonSwap()
+		if err != nil {
+			log.Fatalln("Unable to restore previous iphash-daemon:", err)
+		}
+	default:
+		return false
+	}
+	return true
+}
@@ -25,6 +25,9 @@ var (
 
 func Start() {
 	flag.Parse()
+	if runCommand() {
+		return
+	}
 	daemon.AddCommand(daemon.StringFlag(signal, "quit"), syscall.SIGQUIT, termHandler)
 	daemon.AddCommand(daemon.StringFlag(signal, "stop"), syscall.SIGTERM, termHandler)
 	daemon.AddCommand(daemon.StringFlag(signal, "reload"), syscall.SIGHUP, reloadHandler)
@@ -48,14 +51,22 @@ func Start() {
 		return
 	}
 
-	d, err := cntxt.Reborn()
-	if err != nil {
-		log.Fatalln(err)
-	}
-	if d != nil {
-		return
+	if os.Getenv(worker.HandoffEnv) == "" {
+		d, err := cntxt.Reborn()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if d != nil {
+			return
+		}
+		defer cntxt.Release()
+	} else { // restarted by self-update, already running as daemon
+		lock, err := daemon.CreatePidFile(pidFileName, 0644)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer lock.Remove()
 	}
-	defer cntxt.Release()
 
 	s := single.New("iphash-daemon")
 	if err := s.CheckLock(); err != nil && err == single.ErrAlreadyRunning {
@@ -75,7 +86,7 @@ func Start() {
 	executor := &worker.Main{Done: done, Stop: stop}
 	go executor.Start()
 
-	err = daemon.ServeSignals()
+	err := daemon.ServeSignals()
 	if err != nil {
 		log.Println("Error:", err)
 	}
@@ -110,6 +121,11 @@ func termHandler(sig os.Signal) error {
 }
 
 func reloadHandler(sig os.Signal) error {
+	err := worker.ReloadConfig()
+	if err != nil {
+		log.Printf("[Error] Reload configuration failed: %#v \n", err)
+		return nil
+	}
 	log.Println("configuration reloaded")
 	return nil
 }
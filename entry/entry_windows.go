@@ -1,6 +1,7 @@
 package entry
 
 import (
+	"flag"
 	"iphash-daemon/worker"
 	"log"
 	"os"
@@ -79,6 +80,10 @@ func Start() {
 
 	// log.Println(status)
 
+	flag.Parse()
+	if runCommand() {
+		return
+	}
 	log.Println("-------------------------")
 	log.Println("- iphash-daemon started -")
 	log.Println("-------------------------")
@@ -0,0 +1,206 @@
+package worker
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+/// Bundle is a tar file containing signed upgrade information and package for offline installation:
+///   info.json        upgrade information, same as the one on upgrade server
+///   info.json.sig    signature of upgrade information
+///   *.tar.gz         package
+const bundleInfoName = "info.json"
+const bundleExt = ".bundle"
+const failedExt = ".failed"
+const dropInterval = time.Second * 30
+
+/// Read signed upgrade information from bundle
+func readBundleInfo(bundleName string) (*upgradeInfo, error) {
+	var data, sig []byte
+	err := walkBundle(bundleName, func(hdr *tar.Header, r io.Reader) error {
+		var err error
+		switch filepath.Base(hdr.Name) {
+		case bundleInfoName:
+			data, err = ioutil.ReadAll(io.LimitReader(r, 1<<20))
+		case bundleInfoName + signatureExt:
+			sig, err = ioutil.ReadAll(io.LimitReader(r, 1<<20))
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if data == nil || sig == nil {
+		return nil, fmt.Errorf("Upgrade information or signature not found in bundle %s", bundleName)
+	}
+	return parseSignedUpgradeInfo(data, sig)
+}
+
+/// Copy package in bundle to working directory, package is verified before renamed to its final name.
+/// Package already copied is kept, rejected is true if bundle does not contain the right package
+func extractBundlePackage(bundleName string, upgradeInfo *upgradeInfo) (rejected bool, err error) {
+	packageName := fmt.Sprintf("iphash-%s-%s-%s.tar.gz", runtime.GOOS, runtime.GOARCH, upgradeInfo.Version)
+	if match, err := verifyPackage(packageName, upgradeInfo); err == nil && match {
+		return false, nil
+	}
+	partName := packageName + partExt
+	found := false
+	err = walkBundle(bundleName, func(hdr *tar.Header, r io.Reader) error {
+		if found || !strings.HasSuffix(hdr.Name, ".tar.gz") {
+			return nil
+		}
+		found = true
+		f, err := os.Create(partName)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(f, r)
+		return err
+	})
+	if err == nil && !found {
+		rejected, err = true, fmt.Errorf("Package not found in bundle %s", bundleName)
+	}
+	if err == nil {
+		var match bool
+		match, err = verifyPackage(partName, upgradeInfo)
+		if err == nil && !match {
+			rejected, err = true, fmt.Errorf("Digest of package in bundle %s differ from upgrade information", bundleName)
+		}
+	}
+	if err != nil {
+		os.Remove(partName)
+		return rejected, err
+	}
+	return false, os.Rename(partName, packageName)
+}
+
+/// Call fn for every regular file in bundle
+func walkBundle(bundleName string, fn func(hdr *tar.Header, r io.Reader) error) error {
+	f, err := os.Open(bundleName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		err = fn(hdr, tr)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+/// Find the first bundle in drop folder
+func findBundle() (string, error) {
+	dropDir := getConfig().DropDir
+	if dropDir == "" {
+		return "", nil
+	}
+	infos, err := ioutil.ReadDir(dropDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), bundleExt) {
+			return filepath.Join(dropDir, info.Name()), nil
+		}
+	}
+	return "", nil
+}
+
+/// Take upgrade information and package from bundle in drop folder, nil is returned if no bundle found.
+/// The bundle is kept until its version is installed or rejected, so that it is taken again by later checks,
+/// invalid bundle is rejected at once
+func bundleUpgradeInfo() (*upgradeInfo, string, error) {
+	bundleName, err := findBundle()
+	if err != nil || bundleName == "" {
+		return nil, "", err
+	}
+	log.Println("Found bundle", bundleName)
+	info, err := readBundleInfo(bundleName)
+	if err != nil {
+		rejectBundle(bundleName)
+		return nil, "", err
+	}
+	rejected, err := extractBundlePackage(bundleName, info)
+	if rejected {
+		rejectBundle(bundleName)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return info, bundleName, nil
+}
+
+/// Rename bundle with failed extension so that it is not tried again
+func rejectBundle(bundleName string) {
+	log.Println("Bundle", bundleName, "is rejected")
+	err := os.Rename(bundleName, bundleName+failedExt)
+	if err != nil {
+		log.Printf("[Error] Rename rejected bundle failed: %#v \n", err)
+	}
+}
+
+/// Verify bundle and queue it in drop folder, the running daemon installs it in the next check
+func InstallBundle(bundleName string) error {
+	err := ReloadConfig()
+	if err != nil {
+		return err
+	}
+	dropDir := getConfig().DropDir
+	if dropDir == "" {
+		return fmt.Errorf("Drop folder is not configured")
+	}
+	info, err := readBundleInfo(bundleName)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dropDir, 0755)
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(bundleName)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	target := filepath.Join(dropDir, fmt.Sprintf("iphash-%s-%s-%s%s", runtime.GOOS, runtime.GOARCH, info.Version, bundleExt))
+	dst, err := os.Create(target + partExt)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	dst.Close()
+	if err != nil {
+		os.Remove(target + partExt)
+		return err
+	}
+	err = os.Rename(target+partExt, target)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Bundle of version", info.Version, "is queued for installation:", target)
+	return nil
+}
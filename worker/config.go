@@ -0,0 +1,151 @@
+package worker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"sync"
+)
+
+const configFileName = "iphash-daemon.json"
+
+type config struct {
+	PublicKeys  []string `json:"public_keys"`  // base64 encoded ed25519 public keys trusted for upgrade information
+	RevokedKeys []string `json:"revoked_keys"` // keys no longer trusted, including built-in ones
+	RejectSHA1  bool     `json:"reject_sha1"`  // reject upgrade information which only has SHA1 digest
+
+	InfoURLs       []string `json:"info_urls"`       // upgrade information urls, tried in order, ${sys}, ${arch} and ${channel} are replaced
+	PackageMirrors []string `json:"package_mirrors"` // base urls of package mirrors, tried in order before url in upgrade information
+	RequestTimeout int      `json:"request_timeout"` // seconds to wait for upgrade information
+
+	ConnectTimeout int                 `json:"connect_timeout"` // seconds to wait for connecting and TLS handshake
+	ReadTimeout    int                 `json:"read_timeout"`    // seconds to wait for response header and every read of body
+	Proxy          string              `json:"proxy"`           // HTTP(S) proxy url, proxy environment variables are used if empty
+	CAFiles        []string            `json:"ca_files"`        // PEM files of root CAs trusted besides system ones
+	CertPins       map[string][]string `json:"cert_pins"`       // host to base64 encoded SHA-256 digests of pinned public keys
+
+	IpfsAPI     string `json:"ipfs_api"`     // API of local ipfs node for fetching package by CID
+	IpfsGateway string `json:"ipfs_gateway"` // gateway of local ipfs node, used if API failed
+
+	Channel string `json:"channel"` // release channel: stable, beta, nightly...
+
+	CheckInterval int     `json:"check_interval"` // minutes between checks of upgrade information
+	CheckJitter   float64 `json:"check_jitter"`   // random fraction added to or removed from interval, like 0.2
+	MaxBackoff    int     `json:"max_backoff"`    // maximum minutes between checks when checks keep failing
+	StartupJitter int     `json:"startup_jitter"` // maximum seconds of random delay of first check
+
+	DownloadRateLimit int64    `json:"download_rate_limit"` // KB per second for package downloads, 0 for unlimited
+	DownloadWindows   []string `json:"download_windows"`    // time windows allowed for package downloads, always if empty
+
+	DropDir string `json:"drop_dir"` // folder watched for offline bundles, disabled if empty
+
+	MaintenanceWindows  []string `json:"maintenance_windows"`  // time windows allowed for switching to new version
+	MaintenanceCron     string   `json:"maintenance_cron"`     // cron expression opening a maintenance window
+	MaintenanceDuration int      `json:"maintenance_duration"` // minutes a maintenance window opened by cron lasts
+	MaintenanceSplay    int      `json:"maintenance_splay"`    // maximum minutes of random delay inside maintenance window
+
+	NodeID string   `json:"node_id"` // identity of this node used by rollout, generated if empty
+	Tags   []string `json:"tags"`    // tags of this node used by rollout
+
+	HealthTimeout  int    `json:"health_timeout"`  // seconds to wait for new version becoming healthy before rolling back
+	AllowDowngrade bool   `json:"allow_downgrade"` // allow switching to a version lower than the current one
+	Hold           string `json:"hold"`            // stay on this version, upgrade information is still checked
+	KeepVersions   int    `json:"keep_versions"`   // number of previous versions kept on disk for rolling back
+	ReservedSpace  int64  `json:"reserved_space"`  // MB of disk space which must stay free after downloading or decompressing
+
+	MaxExtractSize  int64 `json:"max_extract_size"`  // maximum bytes of package after decompressing
+	MaxExtractFiles int64 `json:"max_extract_files"` // maximum number of entries in package
+	AllowLinks      bool  `json:"allow_links"`       // allow symbolic links and hard links inside package folder
+
+	RequirePackageManifest bool `json:"require_package_manifest"` // refuse packages without manifest file
+
+	IntegrityInterval int  `json:"integrity_interval"` // minutes between integrity checks of installed files, 0 to disable
+	IntegrityRepair   bool `json:"integrity_repair"`   // decompress package again and restart when installed files are modified
+
+	Hooks       map[string][]string `json:"hooks"`        // phase to hook command and arguments: pre-download, pre-stop, post-start, on-rollback
+	HookTimeout int                 `json:"hook_timeout"` // seconds to wait for a hook command before killing it
+}
+
+var (
+	configLock    sync.RWMutex
+	currentConfig = defaultConfig()
+)
+
+func defaultConfig() *config {
+	return &config{
+		InfoURLs:       []string{defaultInfoURL},
+		RequestTimeout: 30,
+		ConnectTimeout: 10,
+		ReadTimeout:    60,
+		IpfsAPI:        "http://127.0.0.1:5001",
+		IpfsGateway:    "http://127.0.0.1:8080",
+		Channel:        defaultChannel,
+		DropDir:        "drop",
+		CheckInterval:  10,
+		CheckJitter:    0.2,
+		MaxBackoff:     240,
+		StartupJitter:  30,
+
+		MaintenanceDuration: 60,
+		HealthTimeout:       30,
+		KeepVersions:        2,
+		ReservedSpace:       100,
+
+		MaxExtractSize:  2 << 30,
+		MaxExtractFiles: 10000,
+
+		IntegrityInterval: 60,
+		HookTimeout:       60,
+	}
+}
+
+/// Load configuration file, missing options keep their default value
+func loadConfig() (*config, error) {
+	conf := defaultConfig()
+	exist, err := pathExists(configFileName)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return conf, nil
+	}
+	data, err := ioutil.ReadFile(configFileName)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, conf)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Channel == "" {
+		conf.Channel = defaultChannel
+	}
+	if conf.HealthTimeout <= 0 { // new version could never become healthy
+		log.Println("Invalid health_timeout", conf.HealthTimeout, ", using default", defaultConfig().HealthTimeout)
+		conf.HealthTimeout = defaultConfig().HealthTimeout
+	}
+	if conf.HookTimeout <= 0 { // every hook would be killed at once
+		log.Println("Invalid hook_timeout", conf.HookTimeout, ", using default", defaultConfig().HookTimeout)
+		conf.HookTimeout = defaultConfig().HookTimeout
+	}
+	return conf, nil
+}
+
+/// Get configuration currently in use
+func getConfig() *config {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return currentConfig
+}
+
+/// Reload configuration file, current configuration is kept if failed
+func ReloadConfig() error {
+	conf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	configLock.Lock()
+	currentConfig = conf
+	configLock.Unlock()
+	return nil
+}
@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+/// Supported digest algorithms, from strongest to weakest
+var digestAlgorithms = []struct {
+	name    string
+	newHash func() hash.Hash
+}{
+	{"sha512", sha512.New},
+	{"sha256", sha256.New},
+	{"sha1", sha1.New},
+}
+
+/// Get all digests of package declared in upgrade information, keyed by algorithm
+func (this *upgradeInfo) digests() map[string]string {
+	digests := make(map[string]string)
+	for algorithm, digest := range this.Digests {
+		digests[strings.ToLower(algorithm)] = strings.ToLower(digest)
+	}
+	if this.SHA512 != "" {
+		digests["sha512"] = strings.ToLower(this.SHA512)
+	}
+	if this.SHA256 != "" {
+		digests["sha256"] = strings.ToLower(this.SHA256)
+	}
+	if this.SHA1 != "" {
+		digests["sha1"] = strings.ToLower(this.SHA1)
+	}
+	return digests
+}
+
+/// Choose the strongest digest of package declared in upgrade information
+func (this *upgradeInfo) digest() (string, string, error) {
+	digests := this.digests()
+	for _, algorithm := range digestAlgorithms {
+		digest, ok := digests[algorithm.name]
+		if !ok {
+			continue
+		}
+		if algorithm.name == "sha1" && getConfig().RejectSHA1 {
+			return "", "", fmt.Errorf("Upgrade information of version %s only has SHA1 digest, which is rejected by configuration", this.Version)
+		}
+		return algorithm.name, digest, nil
+	}
+	return "", "", fmt.Errorf("Upgrade information of version %s has no supported digest", this.Version)
+}
+
+/// Calculate digest of file with given algorithm
+func hashFile(fileName, algorithm string) (string, error) {
+	var h hash.Hash
+	for _, a := range digestAlgorithms {
+		if a.name == algorithm {
+			h = a.newHash()
+		}
+	}
+	if h == nil {
+		return "", fmt.Errorf("Unsupported digest algorithm: %s", algorithm)
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+/// Check whether file matches the strongest digest in upgrade information
+func verifyPackage(fileName string, upgradeInfo *upgradeInfo) (bool, error) {
+	algorithm, digest, err := upgradeInfo.digest()
+	if err != nil {
+		return false, err
+	}
+	fileDigest, err := hashFile(fileName, algorithm)
+	if err != nil {
+		return false, err
+	}
+	return fileDigest == digest, nil
+}
@@ -0,0 +1,121 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+const partExt = ".part"
+const downloadRetries = 3
+
+/// Download package into a temporary part file, the part file is renamed to package name only after its digest has been verified
+func downloadPackage(upgradeInfo *upgradeInfo, packageName string) error {
+	partName := packageName + partExt
+	err := downloadFile(upgradeInfo.URL, partName)
+	if err != nil {
+		return err
+	}
+	match, err := verifyPackage(partName, upgradeInfo)
+	if err != nil {
+		return err
+	}
+	if !match {
+		os.Remove(partName)
+		return fmt.Errorf("Digest of downloaded package %s differ from upgrade information", packageName)
+	}
+	return os.Rename(partName, packageName)
+}
+
+/// Download file of url into part file, resume from where the part file ends when interrupted,
+/// the same file on next mirror is tried when one fails
+func downloadFile(url, partName string) error {
+	fileName := urlFileName(url)
+	err := downloadAllowed()
+	if err != nil {
+		return err
+	}
+LOOP:
+	for i := 0; i < downloadRetries; i++ {
+		if i > 0 {
+			time.Sleep(time.Second * 5)
+		}
+		for _, mirror := range packageMirrors(url) {
+			err = downloadPart(mirror+"/"+fileName, partName)
+			if err == nil {
+				if e := updateState(func(state *daemonState) { state.LastMirror = mirror }); e != nil {
+					log.Printf("[Error] Save state file failed: %#v \n", e)
+				}
+				break LOOP
+			}
+			if err == errOutsideWindow { // part file is resumed in next download window
+				return err
+			}
+			log.Printf("[Error] Download %s from %s interrupted: %#v \n", fileName, mirror, err)
+		}
+	}
+	return err
+}
+
+/// Download rest content of url to part file by HTTP Range request
+func downloadPart(url, partName string) error {
+	err := checkScheme(url)
+	if err != nil {
+		return err
+	}
+	c, err := httpClient()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(partName, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		log.Printf("Resume downloading %s from %d bytes \n", partName, offset)
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		var start int64
+		_, err = fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &start)
+		if err != nil || start != offset {
+			f.Truncate(0)
+			return fmt.Errorf("Unexpected Content-Range: %s", resp.Header.Get("Content-Range"))
+		}
+	case http.StatusOK: // server does not support range request, download from the beginning
+		if offset > 0 {
+			err = f.Truncate(0)
+			if err != nil {
+				return err
+			}
+			_, err = f.Seek(0, io.SeekStart)
+			if err != nil {
+				return err
+			}
+		}
+	case http.StatusRequestedRangeNotSatisfiable: // part file has been completed
+		return nil
+	default:
+		return fmt.Errorf("Download %s failed: %s", url, resp.Status)
+	}
+	_, err = io.Copy(f, newThrottledReader(resp.Body))
+	return err
+}
@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"runtime"
+	"strings"
+)
+
+const defaultInfoURL = "http://hash.iptokenmain.com/upgrade/iphash-${sys}-${arch}.json"
+const defaultChannel = "stable"
+
+/// Replace ${sys}, ${arch} and ${channel} in url with current operating system, architecture and release channel
+func expandURL(url string) string {
+	url = strings.Replace(url, "${sys}", runtime.GOOS, -1)
+	url = strings.Replace(url, "${arch}", runtime.GOARCH, -1)
+	return strings.Replace(url, "${channel}", getConfig().Channel, -1)
+}
+
+/// Get base part of url without file name
+func urlBase(url string) string {
+	return strings.TrimRight(url[:strings.LastIndex(url, "/")+1], "/")
+}
+
+/// Get file name part of url
+func urlFileName(url string) string {
+	return url[strings.LastIndex(url, "/")+1:]
+}
+
+/// Move the one which worked last time to the front, keep order of others
+func preferLastWorked(candidates []string, last string) []string {
+	ordered := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		if c == last {
+			ordered = append(ordered, c)
+		}
+	}
+	for _, c := range candidates {
+		if c != last {
+			ordered = append(ordered, c)
+		}
+	}
+	return ordered
+}
+
+/// Get upgrade information urls in the order they should be tried
+func infoURLs() []string {
+	var urls []string
+	for _, url := range getConfig().InfoURLs {
+		urls = append(urls, expandURL(url))
+	}
+	state, err := readState()
+	if err != nil {
+		return urls
+	}
+	return preferLastWorked(urls, state.LastInfoURL)
+}
+
+/// Get mirrors of package file in the order they should be tried, url in upgrade information is the last choice
+func packageMirrors(url string) []string {
+	var mirrors []string
+	for _, mirror := range getConfig().PackageMirrors {
+		mirrors = append(mirrors, strings.TrimRight(expandURL(mirror), "/"))
+	}
+	mirrors = append(mirrors, urlBase(url))
+	state, err := readState()
+	if err != nil {
+		return mirrors
+	}
+	return preferLastWorked(mirrors, state.LastMirror)
+}
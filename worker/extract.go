@@ -0,0 +1,249 @@
+package worker
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/// Permission bits allowed from package, setuid, setgid, sticky and write by others are dropped
+const extractModeMask os.FileMode = 0755
+
+/// Decompress tar.gz file into dest, all entries must be inside root folder under dest,
+/// total size and number of files are limited, links are only created when allowed by configuration,
+/// permission bits and modification time of files and folders are taken from package
+func deCompress(tarFile, dest, root string) error {
+	conf := getConfig()
+	srcFile, err := os.Open(tarFile)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	gr, err := gzip.NewReader(srcFile)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	rootPath, err := filepath.Abs(filepath.Join(dest, root))
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(rootPath, 0755)
+	if err != nil {
+		return err
+	}
+	var totalSize, totalFiles int64
+	var dirs []string
+	dirHeaders := make(map[string]*tar.Header)
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			} else {
+				return err
+			}
+		}
+		totalFiles++
+		if totalFiles > conf.MaxExtractFiles {
+			return fmt.Errorf("Package contains more than %d files", conf.MaxExtractFiles)
+		}
+		filename, err := entryPath(rootPath, dest, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeDir {
+			err = makeParent(rootPath, filename)
+			if err != nil {
+				return err
+			}
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if filename != rootPath {
+				err = makeParent(rootPath, filename)
+			}
+			if err == nil {
+				// owner must be able to write files into folder, final mode is set after all files are written
+				err = os.MkdirAll(filename, 0700)
+			}
+			dirs = append(dirs, filename)
+			dirHeaders[filename] = hdr
+		case tar.TypeReg, tar.TypeRegA:
+			totalSize += hdr.Size
+			if totalSize > conf.MaxExtractSize {
+				return fmt.Errorf("Package is larger than %d bytes after decompressing", conf.MaxExtractSize)
+			}
+			err = extractFile(filename, tr, hdr)
+		case tar.TypeSymlink:
+			if !conf.AllowLinks {
+				return fmt.Errorf("Symbolic link %s is not allowed in package", hdr.Name)
+			}
+			target := hdr.Linkname
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(filename), target)
+			}
+			if !insidePath(rootPath, target) {
+				return fmt.Errorf("Symbolic link %s points outside of package: %s", hdr.Name, hdr.Linkname)
+			}
+			err = createLink(filename, hdr.Linkname, os.Symlink)
+		case tar.TypeLink:
+			if !conf.AllowLinks {
+				return fmt.Errorf("Hard link %s is not allowed in package", hdr.Name)
+			}
+			var target string
+			target, err = entryPath(rootPath, dest, hdr.Linkname)
+			if err != nil {
+				return err
+			}
+			err = createLink(filename, target, os.Link)
+		default:
+			return fmt.Errorf("Unsupported type %c of entry %s in package", hdr.Typeflag, hdr.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if conf.AllowLinks {
+		err = checkLinks(rootPath)
+		if err != nil {
+			return err
+		}
+	}
+	// set folders from the deepest one, so that their modification time is not changed afterwards
+	for i := len(dirs) - 1; i >= 0; i-- {
+		err = setAttributes(dirs[i], dirHeaders[dirs[i]])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/// Get path of tar entry and make sure it is inside root folder
+func entryPath(rootPath, dest, name string) (string, error) {
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("Absolute path %s is not allowed in package", name)
+	}
+	path, err := filepath.Abs(filepath.Join(dest, filepath.FromSlash(name)))
+	if err != nil {
+		return "", err
+	}
+	if !insidePath(rootPath, path) {
+		return "", fmt.Errorf("Entry %s is outside of package folder", name)
+	}
+	return path, nil
+}
+
+/// Check whether path is root or inside root
+func insidePath(root, path string) bool {
+	rel, err := filepath.Rel(root, filepath.Clean(path))
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator)) && !filepath.IsAbs(rel)
+}
+
+/// Create parent folder of file, the folder must be inside root after resolving symbolic links
+func makeParent(rootPath, filename string) error {
+	existing := filepath.Dir(filename)
+	for existing != rootPath {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	realRoot, err := filepath.EvalSymlinks(rootPath)
+	if err != nil {
+		return err
+	}
+	realPath, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return err
+	}
+	if !insidePath(realRoot, realPath) {
+		return fmt.Errorf("Entry %s is outside of package folder after resolving links", filename)
+	}
+	return os.MkdirAll(filepath.Dir(filename), 0755)
+}
+
+/// Make sure all symbolic links in root resolve to paths inside root
+func checkLinks(rootPath string) error {
+	realRoot, err := filepath.EvalSymlinks(rootPath)
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		realPath, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return fmt.Errorf("Symbolic link %s can not be resolved: %v", path, err)
+		}
+		if !insidePath(realRoot, realPath) {
+			return fmt.Errorf("Symbolic link %s points outside of package", path)
+		}
+		return nil
+	})
+}
+
+/// Write content of current tar entry to file, at most size of entry is written
+func extractFile(filename string, r io.Reader, hdr *tar.Header) error {
+	file, err := createFile(filename)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(file, io.LimitReader(r, hdr.Size))
+	file.Close()
+	if err != nil {
+		return err
+	}
+	if n != hdr.Size {
+		return fmt.Errorf("Entry %s is truncated", filename)
+	}
+	return setAttributes(filename, hdr)
+}
+
+/// Set permission bits and modification time of file or folder from tar header
+func setAttributes(filename string, hdr *tar.Header) error {
+	mode := hdr.FileInfo().Mode().Perm() & extractModeMask
+	if hdr.Typeflag == tar.TypeDir {
+		mode |= 0700
+	}
+	err := os.Chmod(filename, mode)
+	if err != nil {
+		return err
+	}
+	if hdr.ModTime.IsZero() {
+		return nil
+	}
+	atime := hdr.AccessTime
+	if atime.IsZero() {
+		atime = hdr.ModTime
+	}
+	return os.Chtimes(filename, atime, hdr.ModTime)
+}
+
+/// Create symbolic link or hard link, existing file is replaced
+func createLink(filename, target string, link func(oldname, newname string) error) error {
+	os.Remove(filename)
+	return link(target, filename)
+}
+
+/// Create file, existing file is removed first so that links are never followed
+func createFile(name string) (*os.File, error) {
+	err := os.Remove(name)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+}
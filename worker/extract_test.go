@@ -0,0 +1,167 @@
+package worker
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+/// Write entries as tar.gz file in dir
+func writeTarGz(t *testing.T, dir string, entries []tarEntry) string {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0644}
+		if e.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if e.typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.body))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "package.tar.gz")
+	if err := ioutil.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+/// Create temporary folder, removed by returned function
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "iphash-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+/// Use configuration modified by fn, previous configuration is restored by returned function
+func withConfig(fn func(conf *config)) func() {
+	conf := defaultConfig()
+	fn(conf)
+	configLock.Lock()
+	previous := currentConfig
+	currentConfig = conf
+	configLock.Unlock()
+	return func() {
+		configLock.Lock()
+		currentConfig = previous
+		configLock.Unlock()
+	}
+}
+
+func TestDeCompress(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	defer withConfig(func(conf *config) {})()
+	tarFile := writeTarGz(t, dir, []tarEntry{
+		{name: "pkg/", typeflag: tar.TypeDir},
+		{name: "pkg/bin/ipfs", typeflag: tar.TypeReg, body: "ipfs"},
+	})
+	dest := filepath.Join(dir, "dest")
+	if err := deCompress(tarFile, dest, "pkg"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dest, "pkg", "bin", "ipfs"))
+	if err != nil || string(data) != "ipfs" {
+		t.Fatalf("extracted file = %q, %v", data, err)
+	}
+}
+
+func TestDeCompressRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		conf    func(conf *config)
+	}{
+		{"traversal", []tarEntry{{name: "pkg/../escaped", typeflag: tar.TypeReg, body: "x"}}, nil},
+		{"absolute path", []tarEntry{{name: "/escaped", typeflag: tar.TypeReg, body: "x"}}, nil},
+		{"wrong root", []tarEntry{{name: "other/escaped", typeflag: tar.TypeReg, body: "x"}}, nil},
+		{"symlink not allowed", []tarEntry{{name: "pkg/link", typeflag: tar.TypeSymlink, linkname: "bin"}}, nil},
+		{"symlink escape", []tarEntry{
+			{name: "pkg/link", typeflag: tar.TypeSymlink, linkname: "../.."},
+			{name: "pkg/link/escaped", typeflag: tar.TypeReg, body: "x"},
+		}, func(conf *config) { conf.AllowLinks = true }},
+		{"absolute symlink", []tarEntry{{name: "pkg/link", typeflag: tar.TypeSymlink, linkname: "/"}},
+			func(conf *config) { conf.AllowLinks = true }},
+		{"hard link escape", []tarEntry{{name: "pkg/link", typeflag: tar.TypeLink, linkname: "package.tar.gz"}},
+			func(conf *config) { conf.AllowLinks = true }},
+		{"size limit", []tarEntry{{name: "pkg/big", typeflag: tar.TypeReg, body: "0123456789"}},
+			func(conf *config) { conf.MaxExtractSize = 9 }},
+		{"file count limit", []tarEntry{
+			{name: "pkg/a", typeflag: tar.TypeReg, body: "a"},
+			{name: "pkg/b", typeflag: tar.TypeReg, body: "b"},
+			{name: "pkg/c", typeflag: tar.TypeReg, body: "c"},
+		}, func(conf *config) { conf.MaxExtractFiles = 2 }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, remove := tempDir(t)
+			defer remove()
+			defer withConfig(func(conf *config) {
+				if test.conf != nil {
+					test.conf(conf)
+				}
+			})()
+			tarFile := writeTarGz(t, dir, test.entries)
+			dest := filepath.Join(dir, "dest")
+			if err := deCompress(tarFile, dest, "pkg"); err == nil {
+				t.Fatal("package is extracted without error")
+			}
+			for _, name := range []string{filepath.Join(dest, "escaped"), filepath.Join(dir, "escaped")} {
+				if _, err := os.Lstat(name); err == nil {
+					t.Fatalf("file %s is written outside of package folder", name)
+				}
+			}
+		})
+	}
+}
+
+func TestDeCompressExistingSymlink(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	defer withConfig(func(conf *config) {})()
+	outside := filepath.Join(dir, "outside")
+	if err := os.MkdirAll(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "dest")
+	if err := os.MkdirAll(filepath.Join(dest, "pkg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// link left in package folder must not be followed
+	if err := os.Symlink(outside, filepath.Join(dest, "pkg", "link")); err != nil {
+		t.Skip("symbolic links are not supported:", err)
+	}
+	tarFile := writeTarGz(t, dir, []tarEntry{{name: "pkg/link/escaped", typeflag: tar.TypeReg, body: "x"}})
+	if err := deCompress(tarFile, dest, "pkg"); err == nil {
+		t.Fatal("package is extracted without error")
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "escaped")); err == nil {
+		t.Fatal("file is written through symbolic link")
+	}
+}
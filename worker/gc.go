@@ -0,0 +1,133 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+/// Read local upgrade information file of installed version
+func readUpgradeInfo() (*upgradeInfo, error) {
+	data, err := ioutil.ReadFile(upgradeFileName)
+	if err != nil {
+		return nil, err
+	}
+	var info upgradeInfo
+	err = json.Unmarshal(data, &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+/// Find packages, part files and decompressed folders in working directory, keyed by version
+func findVersionFiles() (map[string][]string, error) {
+	prefix := fmt.Sprintf("iphash-%s-%s-", runtime.GOOS, runtime.GOARCH)
+	infos, err := ioutil.ReadDir(".")
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]string)
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		version := strings.TrimPrefix(name, prefix)
+		if !info.IsDir() {
+			version = strings.TrimSuffix(version, partExt)
+			version = strings.TrimSuffix(version, patchExt)
+			version = strings.TrimSuffix(version, patchedExt)
+			if !strings.HasSuffix(version, ".tar.gz") {
+				continue
+			}
+			version = strings.TrimSuffix(version, ".tar.gz")
+		}
+		files[version] = append(files[version], name)
+	}
+	return files, nil
+}
+
+/// Remove packages and folders of versions older than current one, except the newest ones kept for rolling back
+/// and the held version, returns number of bytes reclaimed
+func collectGarbage(current string) (int64, error) {
+	if current == "" {
+		return 0, fmt.Errorf("No installed version")
+	}
+	files, err := findVersionFiles()
+	if err != nil {
+		return 0, err
+	}
+	hold, err := heldVersion()
+	if err != nil {
+		return 0, err
+	}
+	var olds []*version
+	oldNames := make(map[*version]string)
+	for name := range files {
+		if name == hold {
+			continue
+		}
+		cmp, err := compareVersions(name, current)
+		if err != nil || cmp >= 0 { // keep current, newer and unknown versions
+			continue
+		}
+		v, _ := parseVersion(name)
+		olds = append(olds, v)
+		oldNames[v] = name
+	}
+	sort.Slice(olds, func(i, j int) bool { return olds[i].compare(olds[j]) > 0 })
+	keep := getConfig().KeepVersions
+	var reclaimed int64
+	for i, v := range olds {
+		if i < keep {
+			continue
+		}
+		for _, name := range files[oldNames[v]] {
+			size := pathSize(name)
+			err = os.RemoveAll(name)
+			if err != nil {
+				return reclaimed, err
+			}
+			log.Println("Removed", name, ",", size, "bytes reclaimed")
+			reclaimed += size
+		}
+	}
+	return reclaimed, nil
+}
+
+/// Get total size of file or folder
+func pathSize(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+/// Remove old packages and folders of installed version, and report space reclaimed
+func CollectGarbage() error {
+	err := ReloadConfig()
+	if err != nil {
+		return err
+	}
+	info, err := readUpgradeInfo()
+	if err != nil {
+		return err
+	}
+	reclaimed, err := collectGarbage(info.Version)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d bytes reclaimed, current version %s and %d previous versions are kept \n", reclaimed, info.Version, getConfig().KeepVersions)
+	return nil
+}
@@ -0,0 +1,41 @@
+package worker
+
+import (
+	"fmt"
+)
+
+/// Get version the daemon is held at, configured hold takes precedence over hold command
+func heldVersion() (string, error) {
+	if hold := getConfig().Hold; hold != "" {
+		return hold, nil
+	}
+	state, err := readState()
+	if err != nil {
+		return "", err
+	}
+	return state.Hold, nil
+}
+
+/// Hold the daemon at version, "current" means the version currently installed
+func Hold(version string) error {
+	if version == "current" {
+		info, err := readUpgradeInfo()
+		if err != nil {
+			return err
+		}
+		version = info.Version
+	}
+	if version == "" {
+		return fmt.Errorf("No version to hold")
+	}
+	return updateState(func(state *daemonState) {
+		state.Hold = version
+	})
+}
+
+/// Release hold set by hold command, hold in configuration file is not affected
+func ReleaseHold() error {
+	return updateState(func(state *daemonState) {
+		state.Hold = ""
+	})
+}
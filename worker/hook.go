@@ -0,0 +1,65 @@
+package worker
+
+import (
+	"bytes"
+	"fmt"
+	"iphash-daemon/arch"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	hookPreDownload = "pre-download" // before downloading package of new version, non-zero exit vetoes the upgrade
+	hookPreStop     = "pre-stop"     // before stopping running version, non-zero exit vetoes the switch
+	hookPostStart   = "post-start"   // after new version has become healthy
+	hookOnRollback  = "on-rollback"  // after rolling back to previous version
+)
+
+/// Time to wait for output of killed hook, processes escaped from its process group may keep output open
+const hookKillWait = time.Second * 5
+
+/// Run hook command configured for phase with versions in environment,
+/// the hook and its children are killed if not exited before timeout
+func runHook(phase, oldVersion, newVersion string) error {
+	conf := getConfig()
+	command := conf.Hooks[phase]
+	if len(command) == 0 {
+		return nil
+	}
+	log.Println("Running", phase, "hook:", command)
+	cmd := arch.CommandExecuteFix(command...)
+	cmd.Env = append(os.Environ(),
+		"IPHASH_HOOK_PHASE="+phase,
+		"IPHASH_OLD_VERSION="+oldVersion,
+		"IPHASH_NEW_VERSION="+newVersion)
+	var outb, errb bytes.Buffer
+	cmd.Stdout = &outb
+	cmd.Stderr = &errb
+	arch.Detach(cmd)
+	err := cmd.Start()
+	if err != nil {
+		return fmt.Errorf("Start %s hook failed: %v", phase, err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(time.Second * time.Duration(conf.HookTimeout)):
+		arch.KillTree(cmd.Process.Pid)
+		select {
+		case <-done:
+		case <-time.After(hookKillWait): // output is still written by left processes, not safe to read
+			return fmt.Errorf("Hook %s failed: timed out after %d seconds", phase, conf.HookTimeout)
+		}
+		err = fmt.Errorf("timed out after %d seconds", conf.HookTimeout)
+	}
+	log.Println(outb.String())
+	if err != nil {
+		log.Println(errb.String())
+		return fmt.Errorf("Hook %s failed: %v", phase, err)
+	}
+	return nil
+}
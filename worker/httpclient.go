@@ -0,0 +1,157 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	clientLock   sync.Mutex
+	clientConfig *config
+	client       *http.Client
+)
+
+/// Connection with read deadline extended before every read, so that a stalled transfer fails
+type timeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (this *timeoutConn) Read(b []byte) (int, error) {
+	err := this.Conn.SetReadDeadline(time.Now().Add(this.timeout))
+	if err != nil {
+		return 0, err
+	}
+	return this.Conn.Read(b)
+}
+
+/// Get shared HTTP client built from configuration, it is rebuilt after configuration reloaded
+func httpClient() (*http.Client, error) {
+	conf := getConfig()
+	clientLock.Lock()
+	defer clientLock.Unlock()
+	if client != nil && clientConfig == conf {
+		return client, nil
+	}
+	c, err := newHTTPClient(conf)
+	if err != nil {
+		return nil, err
+	}
+	client, clientConfig = c, conf
+	return client, nil
+}
+
+/// Get HTTP client for small requests like upgrade information, whole request is limited by request timeout
+func requestClient() (*http.Client, error) {
+	c, err := httpClient()
+	if err != nil {
+		return nil, err
+	}
+	limited := *c
+	limited.Timeout = time.Second * time.Duration(getConfig().RequestTimeout)
+	return &limited, nil
+}
+
+func newHTTPClient(conf *config) (*http.Client, error) {
+	readTimeout := time.Second * time.Duration(conf.ReadTimeout)
+	dialer := &net.Dialer{Timeout: time.Second * time.Duration(conf.ConnectTimeout), KeepAlive: time.Second * 30}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil || readTimeout <= 0 {
+				return conn, err
+			}
+			return &timeoutConn{Conn: conn, timeout: readTimeout}, nil
+		},
+		TLSHandshakeTimeout:   time.Second * time.Duration(conf.ConnectTimeout),
+		ResponseHeaderTimeout: readTimeout,
+		IdleConnTimeout:       time.Second * 90,
+	}
+	if conf.Proxy != "" {
+		proxyURL, err := url.Parse(conf.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid proxy %s: %v", conf.Proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	tlsConfig := &tls.Config{}
+	if len(conf.CAFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, file := range conf.CAFiles {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("No certificate found in CA file %s", file)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(conf.CertPins) > 0 {
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyPins(conf.CertPins, state)
+		}
+	}
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("Stopped after 10 redirects")
+			}
+			return checkScheme(req.URL.String())
+		},
+	}, nil
+}
+
+/// Verify certificate chain of pinned host contains a certificate whose public key matches a pin,
+/// pins are base64 encoded SHA-256 digests of DER encoded SubjectPublicKeyInfo
+func verifyPins(pins map[string][]string, state tls.ConnectionState) error {
+	hostPins, ok := pins[state.ServerName]
+	if !ok || len(hostPins) == 0 {
+		return nil
+	}
+	for _, chain := range state.VerifiedChains {
+		for _, cert := range chain {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			if containsString(hostPins, base64.StdEncoding.EncodeToString(sum[:])) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("Certificate of %s does not match pinned keys", state.ServerName)
+}
+
+/// Check whether HTTPS has been configured, plain HTTP is refused once any upgrade information url uses HTTPS
+func httpsRequired() bool {
+	for _, u := range getConfig().InfoURLs {
+		if strings.HasPrefix(strings.ToLower(u), "https://") {
+			return true
+		}
+	}
+	return false
+}
+
+/// Refuse plain HTTP url when HTTPS is required
+func checkScheme(u string) error {
+	if httpsRequired() && !strings.HasPrefix(strings.ToLower(u), "https://") {
+		return fmt.Errorf("Plain HTTP url %s is refused when HTTPS is configured", u)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package worker
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+/// Build manifest of files in package from tar entries, so that installed files can be compared
+/// with package even if manifest file in folder has been modified too
+func manifestFromPackage(packageName, folderName string) (*packageManifest, error) {
+	srcFile, err := os.Open(packageName)
+	if err != nil {
+		return nil, err
+	}
+	defer srcFile.Close()
+	gr, err := gzip.NewReader(srcFile)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	manifest := &packageManifest{}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			} else {
+				return nil, err
+			}
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, tr)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Files = append(manifest.Files, packageFile{
+			Path:   strings.TrimPrefix(strings.TrimPrefix(hdr.Name, "./"), folderName+"/"),
+			Size:   hdr.Size,
+			SHA256: hex.EncodeToString(h.Sum(nil)),
+			Mode:   fmt.Sprintf("%o", hdr.FileInfo().Mode().Perm()),
+		})
+	}
+	return manifest, nil
+}
+
+/// Compare installed files of version with its cached package
+func checkIntegrity(upgradeInfo *upgradeInfo) error {
+	packageName := fmt.Sprintf("iphash-%s-%s-%s.tar.gz", runtime.GOOS, runtime.GOARCH, upgradeInfo.Version)
+	folderName := fmt.Sprintf("iphash-%s-%s-%s", runtime.GOOS, runtime.GOARCH, upgradeInfo.Version)
+	match, err := verifyPackage(packageName, upgradeInfo)
+	if err != nil {
+		return err
+	}
+	if !match {
+		return fmt.Errorf("Digest of cached package %s differ from upgrade information", packageName)
+	}
+	manifest, err := manifestFromPackage(packageName, folderName)
+	if err != nil {
+		return err
+	}
+	return manifest.verify(folderName)
+}
+
+/// Result of integrity check of a version
+type integrityResult struct {
+	info upgradeInfo
+	err  error
+}
+
+/// Check installed files of version in background, so that hashing does not block the daemon
+func scanIntegrity(info upgradeInfo) <-chan integrityResult {
+	result := make(chan integrityResult, 1)
+	go func() {
+		result <- integrityResult{info: info, err: checkIntegrity(&info)}
+	}()
+	return result
+}
+
+/// Record result of integrity check of running version, decompress package again and restart processes if repair is enabled
+func (this *Main) integrityChecked(result integrityResult) {
+	info, err := result.info, result.err
+	if this.pManager == nil || this.pManager.upgradeInfo.Version != info.Version { // switched during the check
+		return
+	}
+	updateStatus(func(status *daemonStatus) {
+		status.LastIntegrityCheck = time.Now()
+		status.Integrity = "ok"
+		if err != nil {
+			status.Integrity = err.Error()
+		}
+	})
+	if err == nil {
+		return
+	}
+	log.Printf("[Error] Integrity check of version %s failed: %#v \n", info.Version, err)
+	if !getConfig().IntegrityRepair {
+		return
+	}
+	log.Println("Repairing installed files of version", info.Version)
+	this.pManager.stop()
+	folderName := fmt.Sprintf("iphash-%s-%s-%s", runtime.GOOS, runtime.GOARCH, info.Version)
+	err = os.RemoveAll(folderName)
+	if err == nil {
+		err = downloadAndDecompress(&info)
+	}
+	if err != nil {
+		log.Printf("[Error] Repair installed files failed: %#v \n", err)
+	}
+	this.pManager = newProcManager(info)
+	err = this.pManager.boot()
+	if err != nil {
+		log.Printf("[Error] Version %s started failed after repairing: %#v \n", info.Version, err)
+	}
+}
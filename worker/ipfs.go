@@ -0,0 +1,109 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+/// Fetch package by CID through local ipfs node, API is tried before gateway,
+/// the result is verified against digest in upgrade information
+func fetchPackageFromIPFS(upgradeInfo *upgradeInfo, packageName string) error {
+	if upgradeInfo.CID == "" {
+		return fmt.Errorf("No CID in upgrade information")
+	}
+	c, err := localClient()
+	if err != nil {
+		return err
+	}
+	conf := getConfig()
+	partName := packageName + partExt
+	if conf.IpfsAPI != "" {
+		apiURL := strings.TrimRight(conf.IpfsAPI, "/") + "/api/v0/cat?arg=" + url.QueryEscape(upgradeInfo.CID)
+		err = fetchFromIPFS(c, "POST", apiURL, partName, true)
+		if err != nil {
+			log.Printf("[Error] Fetch %s through ipfs API failed: %#v \n", upgradeInfo.CID, err)
+		}
+	}
+	if conf.IpfsAPI == "" || err != nil {
+		if conf.IpfsGateway == "" {
+			return fmt.Errorf("Neither ipfs API nor gateway is configured")
+		}
+		gatewayURL := strings.TrimRight(conf.IpfsGateway, "/") + "/ipfs/" + upgradeInfo.CID
+		err = fetchFromIPFS(c, "GET", gatewayURL, partName, false)
+		if err != nil {
+			return err
+		}
+	}
+	match, err := verifyPackage(partName, upgradeInfo)
+	if err != nil {
+		return err
+	}
+	if !match {
+		os.Remove(partName)
+		return fmt.Errorf("Digest of package %s fetched by CID differ from upgrade information", packageName)
+	}
+	return os.Rename(partName, packageName)
+}
+
+/// Fetch rest content into part file, API resumes by offset parameter and gateway by Range header
+func fetchFromIPFS(c *http.Client, method, u, partName string, api bool) error {
+	f, err := os.OpenFile(partName, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if api && offset > 0 {
+		u = fmt.Sprintf("%s&offset=%d", u, offset)
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	if !api && offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && !api:
+	case resp.StatusCode == http.StatusOK:
+		if offset > 0 && !api { // gateway does not support range request, fetch from the beginning
+			err = f.Truncate(0)
+			if err == nil {
+				_, err = f.Seek(0, io.SeekStart)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && !api:
+		return nil
+	default:
+		return fmt.Errorf("Fetch %s failed: %s", u, resp.Status)
+	}
+	_, err = io.Copy(f, newThrottledReader(resp.Body))
+	return err
+}
+
+/// Get HTTP client for local ipfs node, proxy is never used
+func localClient() (*http.Client, error) {
+	c, err := newHTTPClient(getConfig())
+	if err != nil {
+		return nil, err
+	}
+	c.Transport.(*http.Transport).Proxy = nil
+	c.CheckRedirect = nil
+	return c, nil
+}
@@ -0,0 +1,43 @@
+package worker
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+/// Check whether new version may be switched to now, always if no maintenance window configured
+func inMaintenanceWindow(t time.Time) (bool, error) {
+	conf := getConfig()
+	if len(conf.MaintenanceWindows) == 0 && conf.MaintenanceCron == "" {
+		return true, nil
+	}
+	if len(conf.MaintenanceWindows) > 0 {
+		open, err := inWindows(conf.MaintenanceWindows, t)
+		if err != nil || open {
+			return open, err
+		}
+	}
+	if conf.MaintenanceCron != "" {
+		schedule, err := cron.Parse(conf.MaintenanceCron)
+		if err != nil {
+			return false, err
+		}
+		// window is open if cron fired during the last duration
+		duration := time.Minute * time.Duration(conf.MaintenanceDuration)
+		if !schedule.Next(t.Add(-duration)).After(t) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+/// Get random delay inside maintenance window, so that nodes do not restart at the same time
+func maintenanceSplay() time.Duration {
+	splay := time.Minute * time.Duration(getConfig().MaintenanceSplay)
+	if splay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(splay)))
+}
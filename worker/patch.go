@@ -0,0 +1,173 @@
+package worker
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+)
+
+/// Patch from a previous version to the version in upgrade information
+type patchInfo struct {
+	From   string `json:"from"`   // version the patch applies to
+	URL    string `json:"url"`    // download url of patch file
+	SHA256 string `json:"sha256"` // digest of patch file
+}
+
+/// Patch file is a gzip compressed stream starting with patchMagic, followed by operations:
+/// 'C' offset(uint64) length(uint64) copies bytes from previous package,
+/// 'I' length(uint64) data inserts bytes from patch, 'E' ends the patch. Integers are big endian.
+const patchMagic = "IPHDIFF1"
+const patchExt = ".patch"
+const patchedExt = ".patched"
+
+/// Find a patch whose previous package is cached locally
+func (this *upgradeInfo) findPatch() (*patchInfo, string) {
+	for i := range this.Patches {
+		patch := &this.Patches[i]
+		oldPackage := fmt.Sprintf("iphash-%s-%s-%s.tar.gz", runtime.GOOS, runtime.GOARCH, patch.From)
+		exist, err := pathExists(oldPackage)
+		if err == nil && exist {
+			return patch, oldPackage
+		}
+	}
+	return nil, ""
+}
+
+/// Build package by patching a cached previous package, result is verified against digest of full package
+func patchPackage(upgradeInfo *upgradeInfo, packageName string) error {
+	patch, oldPackage := upgradeInfo.findPatch()
+	if patch == nil {
+		return fmt.Errorf("No patch applies to cached packages")
+	}
+	if patch.SHA256 == "" {
+		return fmt.Errorf("Patch from %s has no digest", patch.From)
+	}
+	log.Println("Downloading patch from", patch.From, "to", upgradeInfo.Version, "...")
+	patchName := packageName + patchExt
+	err := downloadFile(patch.URL, patchName+partExt)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(patchName+partExt, patchName)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(patchName)
+	digest, err := hashFile(patchName, "sha256")
+	if err != nil {
+		return err
+	}
+	if digest != strings.ToLower(patch.SHA256) {
+		return fmt.Errorf("Digest of patch %s differ from upgrade information", patchName)
+	}
+	limit := upgradeInfo.Size
+	if limit <= 0 { // size of package not declared, patched package can not be larger than both files
+		oldStat, err := os.Stat(oldPackage)
+		if err != nil {
+			return err
+		}
+		patchStat, err := os.Stat(patchName)
+		if err != nil {
+			return err
+		}
+		limit = oldStat.Size() + patchStat.Size()
+	}
+	partName := packageName + patchedExt
+	err = applyPatch(oldPackage, patchName, partName, limit)
+	if err == nil {
+		var match bool
+		match, err = verifyPackage(partName, upgradeInfo)
+		if err == nil && !match {
+			err = fmt.Errorf("Digest of patched package %s differ from upgrade information", packageName)
+		}
+	}
+	if err != nil {
+		os.Remove(partName)
+		return err
+	}
+	return os.Rename(partName, packageName)
+}
+
+/// Writer failing when more than limit bytes are written
+type limitedWriter struct {
+	w     io.Writer
+	limit int64
+}
+
+func (this *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > this.limit {
+		return 0, fmt.Errorf("Patched package is larger than expected")
+	}
+	this.limit -= int64(len(p))
+	return this.w.Write(p)
+}
+
+/// Apply patch to old file and write result to new file, which must not be larger than limit
+func applyPatch(oldName, patchName, newName string, limit int64) error {
+	oldFile, err := os.Open(oldName)
+	if err != nil {
+		return err
+	}
+	defer oldFile.Close()
+	patchFile, err := os.Open(patchName)
+	if err != nil {
+		return err
+	}
+	defer patchFile.Close()
+	gr, err := gzip.NewReader(patchFile)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	newFile, err := os.Create(newName)
+	if err != nil {
+		return err
+	}
+	defer newFile.Close()
+	r := bufio.NewReader(gr)
+	w := bufio.NewWriter(&limitedWriter{w: newFile, limit: limit})
+	magic := make([]byte, len(patchMagic))
+	_, err = io.ReadFull(r, magic)
+	if err != nil || string(magic) != patchMagic {
+		return fmt.Errorf("Invalid patch file %s", patchName)
+	}
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case 'C':
+			var args [2]uint64
+			err = binary.Read(r, binary.BigEndian, &args)
+			if err != nil {
+				return err
+			}
+			var n int64
+			n, err = io.Copy(w, io.NewSectionReader(oldFile, int64(args[0]), int64(args[1])))
+			if err == nil && n != int64(args[1]) {
+				err = fmt.Errorf("Copy range of patch file %s is out of previous package", patchName)
+			}
+		case 'I':
+			var length uint64
+			err = binary.Read(r, binary.BigEndian, &length)
+			if err != nil {
+				return err
+			}
+			_, err = io.CopyN(w, r, int64(length))
+		case 'E':
+			return w.Flush()
+		default:
+			return fmt.Errorf("Invalid operation %c in patch file %s", op, patchName)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
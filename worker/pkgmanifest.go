@@ -0,0 +1,114 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"iphash-daemon/arch"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+/// Name of manifest file inside package folder
+const packageManifestName = "manifest.json"
+
+/// Manifest inside package, lists every file of package
+type packageManifest struct {
+	Files []packageFile `json:"files"`
+}
+
+type packageFile struct {
+	Path   string `json:"path"`   // path relative to package folder, separated by "/"
+	Size   int64  `json:"size"`   // size in bytes
+	SHA256 string `json:"sha256"` // hex encoded SHA-256 digest
+	Mode   string `json:"mode"`   // octal permission bits, like "0755"
+}
+
+/// Verify decompressed folder against manifest inside package,
+/// folder of package without manifest is only checked for required files
+func verifyFolder(folderName string) error {
+	manifestPath := filepath.Join(folderName, packageManifestName)
+	exist, err := pathExists(manifestPath)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		if getConfig().RequirePackageManifest {
+			return fmt.Errorf("Manifest file %s not found", manifestPath)
+		}
+		for _, name := range []string{"ipfs-monitor" + arch.ExtExecution(), "ipfs" + arch.ExtExecution(), "install" + arch.ExtScript()} {
+			exist, _ := pathExists(filepath.Join(folderName, name))
+			if !exist {
+				return fmt.Errorf("File %s not found", name)
+			}
+		}
+		return nil
+	}
+	data, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return err
+	}
+	var manifest packageManifest
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return err
+	}
+	return manifest.verify(folderName)
+}
+
+/// Verify every file listed in manifest, files not listed are not expected except manifest file itself
+func (this *packageManifest) verify(folderName string) error {
+	manifestPath := filepath.Join(folderName, packageManifestName)
+	listed := make(map[string]bool)
+	for _, f := range this.Files {
+		path := filepath.Join(folderName, filepath.FromSlash(f.Path))
+		listed[path] = true
+		err := verifyFile(path, f)
+		if err != nil {
+			return err
+		}
+	}
+	return filepath.Walk(folderName, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || path == manifestPath || listed[path] {
+			return nil
+		}
+		return fmt.Errorf("File %s is not listed in manifest", path)
+	})
+}
+
+/// Verify size, digest and permission bits of file
+func verifyFile(path string, f packageFile) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("File %s is not a regular file", path)
+	}
+	if info.Size() != f.Size {
+		return fmt.Errorf("Size of file %s is %d, %d expected", path, info.Size(), f.Size)
+	}
+	if f.Mode != "" && runtime.GOOS != "windows" {
+		mode, err := strconv.ParseUint(f.Mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("Invalid mode %s of file %s in manifest", f.Mode, f.Path)
+		}
+		expected := os.FileMode(mode) & extractModeMask
+		if info.Mode().Perm() != expected {
+			return fmt.Errorf("Mode of file %s is %s, %s expected", path, info.Mode().Perm(), expected)
+		}
+	}
+	digest, err := hashFile(path, "sha256")
+	if err != nil {
+		return err
+	}
+	if digest != f.SHA256 {
+		return fmt.Errorf("Digest of file %s differ from manifest", path)
+	}
+	return nil
+}
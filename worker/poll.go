@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/// Delay used when server asks to retry later without Retry-After header
+const defaultRetryAfter = time.Minute * 30
+const maxRetryAfter = time.Hour * 24
+
+/// Upgrade information fetched last time and its validators, for conditional requests
+type infoCache struct {
+	ETag         string `json:"etag"`
+	LastModified string `json:"last_modified"`
+	Data         []byte `json:"data"`
+	Sig          []byte `json:"sig"`
+}
+
+/// Error returned when server is overloaded and asks to retry later
+type retryAfterError struct {
+	url   string
+	delay time.Duration
+}
+
+func (this *retryAfterError) Error() string {
+	return fmt.Sprintf("Server of %s asks to retry after %s", this.url, this.delay)
+}
+
+/// Parse Retry-After header, which is either seconds or HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	delay := defaultRetryAfter
+	if seconds, err := strconv.Atoi(value); err == nil {
+		delay = time.Second * time.Duration(seconds)
+	} else if t, err := http.ParseTime(value); err == nil {
+		delay = t.Sub(now)
+	}
+	if delay <= 0 {
+		delay = defaultRetryAfter
+	}
+	if delay > maxRetryAfter {
+		delay = maxRetryAfter
+	}
+	return delay
+}
+
+/// Get content of url, validators of cache are sent so that nil data is returned if not modified
+func httpGetConditional(url string, cache *infoCache) ([]byte, *infoCache, error) {
+	err := checkScheme(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := requestClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cache != nil && cache.Data != nil {
+		if cache.ETag != "" {
+			req.Header.Set("If-None-Match", cache.ETag)
+		}
+		if cache.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cache.LastModified)
+		}
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		if cache == nil || cache.Data == nil {
+			return nil, nil, fmt.Errorf("Get %s failed: %s without cached content", url, resp.Status)
+		}
+		return nil, cache, nil
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return nil, nil, &retryAfterError{url: url, delay: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	default:
+		return nil, nil, fmt.Errorf("Get %s failed: %s", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, &infoCache{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified"), Data: data}, nil
+}
@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"iphash-daemon/arch"
+	"os"
+)
+
+/// Minimum free inodes required when number of files is unknown
+const minFreeInodes = 100
+
+/// Check working directory is writable and has enough space and inodes, besides the reserved free space
+func preflight(stage string, bytes int64, files int64) error {
+	f, err := ioutil.TempFile(".", ".preflight")
+	if err != nil {
+		return fmt.Errorf("Pre-flight check before %s failed, working directory is not writable: %v", stage, err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	freeBytes, freeInodes, totalInodes, err := arch.DiskFree(".")
+	if err != nil {
+		return fmt.Errorf("Pre-flight check before %s failed, can not get free space: %v", stage, err)
+	}
+	required := uint64(bytes) + uint64(getConfig().ReservedSpace)*1024*1024
+	if freeBytes < required {
+		return fmt.Errorf("Pre-flight check before %s failed, %d bytes free, %d bytes required", stage, freeBytes, required)
+	}
+	if files < minFreeInodes {
+		files = minFreeInodes
+	}
+	if totalInodes > 0 && freeInodes < uint64(files) { // file systems like btrfs have no inode limit and report 0
+		return fmt.Errorf("Pre-flight check before %s failed, %d inodes free, %d inodes required", stage, freeInodes, files)
+	}
+	return nil
+}
+
+/// Check resources before downloading package, part file already downloaded is not counted again
+func preflightDownload(upgradeInfo *upgradeInfo, packageName string) error {
+	size := upgradeInfo.Size
+	if info, err := os.Stat(packageName + partExt); err == nil {
+		size -= info.Size()
+	}
+	if size < 0 {
+		size = 0
+	}
+	return preflight("download", size, 0)
+}
+
+/// Check resources before decompressing package
+func preflightDecompress(upgradeInfo *upgradeInfo) error {
+	return preflight("decompress", upgradeInfo.UnpackedSize, upgradeInfo.FileCount)
+}
@@ -20,7 +20,8 @@ type procManager struct {
 	ipfsSig     chan struct{}
 }
 
-func (this *procManager) boot() {
+/// Start all processes, error is returned if ipfs does not become healthy in time
+func (this *procManager) boot() error {
 	this.init()
 	this.prepare()
 	go this.executeIpfs()
@@ -30,6 +31,7 @@ func (this *procManager) boot() {
 	}
 	go this.executeMonitor()
 	time.Sleep(time.Second * 3)
+	return err
 }
 
 func (this *procManager) init() {
@@ -65,8 +67,8 @@ func (this *procManager) prepare() {
 
 func (this *procManager) check() error {
 	folderName := fmt.Sprintf("iphash-%s-%s-%s", runtime.GOOS, runtime.GOARCH, this.upgradeInfo.Version)
-	i := 30
-	for i > 0 {
+	deadline := time.Now().Add(time.Second * time.Duration(getConfig().HealthTimeout))
+	for time.Now().Before(deadline) {
 		cmd := exec.Command(folderName+string(os.PathSeparator)+"ipfs"+arch.ExtExecution(), "stats", "bw")
 		var outb, errb bytes.Buffer
 		cmd.Stdout = &outb
@@ -74,8 +76,6 @@ func (this *procManager) check() error {
 		err := cmd.Run()
 		if err == nil {
 			return nil
-		} else {
-			i = i - 1
 		}
 		// procCheck, err := os.StartProcess(folderName+"/ipfs", []string{"ipfs", "stats", "bw"}, &os.ProcAttr{Files: []*os.File{os.Stdin, os.Stdout, os.Stderr}})
 		// if err == nil {
@@ -91,6 +91,9 @@ func (this *procManager) check() error {
 
 func (this *procManager) executeIpfs() {
 	folderName := fmt.Sprintf("iphash-%s-%s-%s", runtime.GOOS, runtime.GOARCH, this.upgradeInfo.Version)
+	if this.ipfs != nil { // taken over from previous daemon process
+		this.ipfs.Wait()
+	}
 	for !this.stopping {
 		procIpfs, err := os.StartProcess(folderName+string(os.PathSeparator)+"ipfs"+arch.ExtExecution(), []string{"ipfs" + arch.ExtExecution(), "daemon"}, &os.ProcAttr{Files: []*os.File{os.Stdin, os.Stdout, os.Stderr}})
 		if err == nil {
@@ -105,13 +108,16 @@ func (this *procManager) executeIpfs() {
 
 func (this *procManager) executeMonitor() {
 	folderName := fmt.Sprintf("iphash-%s-%s-%s", runtime.GOOS, runtime.GOARCH, this.upgradeInfo.Version)
+	if this.monitor != nil { // taken over from previous daemon process
+		this.monitor.Wait()
+	}
 	for !this.stopping {
 		procMonitor, err := os.StartProcess(folderName+string(os.PathSeparator)+"ipfs-monitor"+arch.ExtExecution(), []string{"ipfs-monitor" + arch.ExtExecution()}, &os.ProcAttr{Files: []*os.File{os.Stdin, os.Stdout, os.Stderr}})
 		if err == nil {
 			this.monitor = procMonitor
 			procMonitor.Wait()
 		} else {
-			log.Println("[Error] Error when starting ipfs-monitor: %#v \n", err)
+			log.Printf("[Error] Error when starting ipfs-monitor: %#v \n", err)
 		}
 	}
 	this.monitorSig <- struct{}{}
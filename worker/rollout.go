@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"runtime"
+)
+
+/// Rollout rules in upgrade information, a node takes part in the rollout if it is listed by
+/// node ID, architecture or tag, or its stable hash falls into the rollout percentage
+type rollout struct {
+	Percentage float64  `json:"percentage"` // 0 - 100
+	NodeIDs    []string `json:"node_ids"`
+	Archs      []string `json:"archs"`
+	Tags       []string `json:"tags"`
+}
+
+/// Get persistent identity of this node, configured node ID takes precedence over generated one
+func nodeID() (string, error) {
+	if id := getConfig().NodeID; id != "" {
+		return id, nil
+	}
+	state, err := readState()
+	if err != nil {
+		return "", err
+	}
+	if state.NodeID != "" {
+		return state.NodeID, nil
+	}
+	b := make([]byte, 16)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	err = updateState(func(state *daemonState) {
+		if state.NodeID == "" {
+			state.NodeID = id
+		}
+		id = state.NodeID
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+/// Check whether this node takes part in rollout of version
+func (this *rollout) includes(version string) (bool, error) {
+	id, err := nodeID()
+	if err != nil {
+		return false, err
+	}
+	if containsString(this.NodeIDs, id) || containsString(this.Archs, runtime.GOARCH) {
+		return true, nil
+	}
+	for _, tag := range getConfig().Tags {
+		if containsString(this.Tags, tag) {
+			return true, nil
+		}
+	}
+	// version is mixed into hash so that early adopters differ between versions
+	sum := sha256.Sum256([]byte(id + ":" + version))
+	bucket := binary.BigEndian.Uint64(sum[:8]) % 10000
+	return float64(bucket) < this.Percentage*100, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
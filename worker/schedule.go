@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"math/rand"
+	"time"
+)
+
+/// Get delay of first check after daemon started, randomized so that nodes restarted together do not check together
+func firstCheckDelay() time.Duration {
+	delay := time.Second * 1
+	jitter := time.Second * time.Duration(getConfig().StartupJitter)
+	if jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(jitter)))
+	}
+	return delay
+}
+
+/// Get delay of next check: base interval doubled for every consecutive failure up to maximum backoff,
+/// randomized by jitter, and not shorter than the delay asked by server
+func nextCheckDelay(failures int, retryAfter time.Duration) time.Duration {
+	conf := getConfig()
+	interval := time.Minute * time.Duration(conf.CheckInterval)
+	if interval <= 0 {
+		interval = time.Minute * 10
+	}
+	maxBackoff := time.Minute * time.Duration(conf.MaxBackoff)
+	delay := interval
+	for i := 0; i < failures && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff && maxBackoff > interval {
+		delay = maxBackoff
+	}
+	if conf.CheckJitter > 0 {
+		spread := int64(float64(delay) * conf.CheckJitter)
+		if spread > 0 {
+			delay += time.Duration(rand.Int63n(2*spread+1) - spread)
+		}
+	}
+	if delay < retryAfter {
+		delay = retryAfter
+	}
+	return delay
+}
@@ -0,0 +1,355 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"iphash-daemon/arch"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/kardianos/osext"
+)
+
+/// Version of iphash-daemon itself, set at build time by: -ldflags "-X iphash-daemon/worker.daemonVersion=v0.2.0"
+var daemonVersion = "v0.1.0"
+
+/// Environment variable passing running processes to the daemon started by self-update
+const HandoffEnv = "IPHASH_DAEMON_HANDOFF"
+
+/// Environment variable marking child process of go-daemon
+const daemonMarkEnv = "_GO_DAEMON"
+
+const newExt = ".new"
+const backupExt = ".old"
+
+/// New daemon must keep running this long before the swap is confirmed
+const daemonConfirmDelay = time.Minute * 2
+
+/// Watchdog restores previous executable if swap is not confirmed this long after confirm delay
+const watchdogGrace = time.Minute
+
+/// Processes handed over to the daemon started by self-update
+type handoff struct {
+	IpfsPid     int `json:"ipfs_pid"`
+	MonitorPid  int `json:"monitor_pid"`
+	WatchdogPid int `json:"watchdog_pid"` // process supervising new daemon until swap is confirmed
+}
+
+/// Executable swap not confirmed yet
+type daemonSwap struct {
+	Version    string `json:"version"`    // version of new daemon
+	Executable string `json:"executable"` // path of executable, previous one is restored to it
+	Backup     string `json:"backup"`     // path of previous executable
+	Starts     int    `json:"starts"`     // times new daemon has started without confirming
+	Pid        int    `json:"pid"`        // process of new daemon
+}
+
+/// Get version of iphash-daemon
+func Version() string {
+	return daemonVersion
+}
+
+/// Check whether new daemon described in upgrade information should be installed
+func needSelfUpdate(daemonInfo *upgradeInfo) (bool, error) {
+	if daemonInfo == nil || daemonInfo.Version == "" {
+		return false, nil
+	}
+	cmp, err := compareVersions(daemonInfo.Version, daemonVersion)
+	if err != nil || cmp <= 0 {
+		return false, err
+	}
+	state, err := readState()
+	if err != nil {
+		return false, err
+	}
+	return !containsString(state.BadDaemonVersions, daemonInfo.Version), nil
+}
+
+/// Download and verify new daemon executable, the executable must report its version correctly
+func prepareSelfUpdate(daemonInfo *upgradeInfo) (string, error) {
+	exe, err := osext.Executable()
+	if err != nil {
+		return "", err
+	}
+	newName := exe + newExt
+	exist, err := pathExists(newName)
+	if err != nil {
+		return "", err
+	}
+	if exist {
+		match, err := verifyPackage(newName, daemonInfo)
+		if err != nil || !match {
+			os.Remove(newName)
+			exist = false
+		}
+	}
+	if !exist {
+		err = downloadAllowed()
+		if err != nil {
+			return "", err
+		}
+		log.Println("Downloading iphash-daemon", daemonInfo.Version, "...")
+		err = downloadPackage(daemonInfo, newName)
+		if err != nil {
+			return "", err
+		}
+	}
+	err = os.Chmod(newName, 0755)
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command(newName, "-version")
+	var outb bytes.Buffer
+	cmd.Stdout = &outb
+	err = cmd.Run()
+	if err != nil || strings.TrimSpace(outb.String()) != daemonInfo.Version {
+		os.Remove(newName)
+		return "", fmt.Errorf("New iphash-daemon executable does not report version %s: %v", daemonInfo.Version, err)
+	}
+	return newName, nil
+}
+
+/// Swap executable with new one and execute it, running processes are handed over to the new daemon,
+/// which is supervised by previous executable until the swap is confirmed.
+/// It only returns when failed, and the previous executable is restored
+func (this *Main) selfUpdate(daemonInfo *upgradeInfo, newName string) error {
+	exe, err := osext.Executable()
+	if err != nil {
+		return err
+	}
+	backup := exe + backupExt
+	os.Remove(backup)
+	err = os.Rename(exe, backup)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(newName, exe)
+	if err != nil {
+		os.Rename(backup, exe)
+		return err
+	}
+	err = updateState(func(state *daemonState) {
+		state.DaemonSwap = &daemonSwap{Version: daemonInfo.Version, Executable: exe, Backup: backup}
+	})
+	var watchdog *exec.Cmd
+	if err == nil {
+		h := this.handoff()
+		watchdog = exec.Command(backup, "-watch-swap")
+		watchdog.Env = handoffEnv(h)
+		watchdog.Stdout, watchdog.Stderr = os.Stdout, os.Stderr
+		arch.Detach(watchdog)
+		err = watchdog.Start()
+		if err == nil {
+			h.WatchdogPid = watchdog.Process.Pid
+			log.Println("Restarting with iphash-daemon", daemonInfo.Version)
+			err = arch.Reexec(exe, os.Args, handoffEnv(h))
+			watchdog.Process.Kill()
+			watchdog.Wait()
+		}
+	}
+	// still running, restore previous executable
+	os.Remove(exe)
+	os.Rename(backup, exe)
+	updateState(func(state *daemonState) {
+		state.DaemonSwap = nil
+	})
+	return err
+}
+
+/// Get running processes to hand over
+func (this *Main) handoff() handoff {
+	var h handoff
+	if this.pManager != nil {
+		if this.pManager.ipfs != nil {
+			h.IpfsPid = this.pManager.ipfs.Pid
+		}
+		if this.pManager.monitor != nil {
+			h.MonitorPid = this.pManager.monitor.Pid
+		}
+	}
+	return h
+}
+
+/// Get environment of new daemon with processes handed over, which skips daemonizing again
+func handoffEnv(h handoff) []string {
+	data, _ := json.Marshal(h)
+	return append(environWithout(HandoffEnv), HandoffEnv+"="+string(data))
+}
+
+/// Get environment of this process without variables of names
+func environWithout(names ...string) []string {
+	var env []string
+	for _, e := range os.Environ() {
+		keep := true
+		for _, name := range names {
+			if strings.HasPrefix(e, name+"=") {
+				keep = false
+			}
+		}
+		if keep {
+			env = append(env, e)
+		}
+	}
+	return env
+}
+
+/// Take over processes of previous daemon process, if started by self-update
+func (this *Main) adopt() {
+	value := os.Getenv(HandoffEnv)
+	if value == "" {
+		return
+	}
+	os.Unsetenv(HandoffEnv)
+	var h handoff
+	err := json.Unmarshal([]byte(value), &h)
+	if err != nil {
+		log.Printf("[Error] Parse handed over processes failed: %#v \n", err)
+		return
+	}
+	if h.WatchdogPid > 0 { // started by this process before executing, reap it when it exits
+		if watchdog, err := os.FindProcess(h.WatchdogPid); err == nil {
+			go watchdog.Wait()
+		}
+	}
+	if h.IpfsPid == 0 && h.MonitorPid == 0 { // nothing to take over, processes are booted by first check
+		return
+	}
+	info, err := readUpgradeInfo()
+	if err != nil {
+		log.Printf("[Error] Read local upgrade information file failed: %#v \n", err)
+		return
+	}
+	pManager := newProcManager(*info)
+	if h.IpfsPid > 0 {
+		pManager.ipfs, _ = os.FindProcess(h.IpfsPid)
+	}
+	if h.MonitorPid > 0 {
+		pManager.monitor, _ = os.FindProcess(h.MonitorPid)
+	}
+	go pManager.executeIpfs()
+	go pManager.executeMonitor()
+	this.pManager = pManager
+	this.versionInfo = *info
+	log.Println("Processes of version", info.Version, "taken over from previous iphash-daemon")
+}
+
+/// Check swap of executable on start, restore previous executable if the new one has started before
+/// without being confirmed, which means it did not keep running
+func checkDaemonSwap() {
+	var swap *daemonSwap
+	err := updateState(func(state *daemonState) {
+		if state.DaemonSwap != nil {
+			state.DaemonSwap.Starts++
+			state.DaemonSwap.Pid = os.Getpid()
+			swap = state.DaemonSwap
+		}
+	})
+	if err != nil {
+		log.Printf("[Error] Read state file failed: %#v \n", err)
+		return
+	}
+	if swap == nil || swap.Starts <= 1 {
+		return
+	}
+	log.Println("iphash-daemon", swap.Version, "did not keep running, restoring previous executable")
+	exe, err := restoreDaemonSwap(swap)
+	if err != nil {
+		log.Printf("[Error] Restore previous executable failed: %#v \n", err)
+		return
+	}
+	// already running as daemon, an empty handoff keeps the previous executable from daemonizing again
+	err = arch.Reexec(exe, os.Args, handoffEnv(handoff{}))
+	log.Printf("[Error] Restart previous executable failed: %#v \n", err)
+}
+
+/// Put previous executable back and mark version of new daemon as bad, returns path of executable.
+/// Path is taken from swap, as the watchdog runs from the backup itself
+func restoreDaemonSwap(swap *daemonSwap) (string, error) {
+	exe := swap.Executable
+	var err error
+	if exe == "" { // swap recorded before executable path was saved
+		exe, err = osext.Executable()
+	}
+	if err == nil {
+		err = os.Rename(swap.Backup, exe)
+	}
+	e := updateState(func(state *daemonState) {
+		state.DaemonSwap = nil
+		if !containsString(state.BadDaemonVersions, swap.Version) {
+			state.BadDaemonVersions = append(state.BadDaemonVersions, swap.Version)
+		}
+	})
+	if err == nil {
+		err = e
+	}
+	return exe, err
+}
+
+/// Supervise new daemon until its swap is confirmed, run by previous executable started before self-update.
+/// If new daemon exits or is not confirmed in time, its processes are killed, previous executable is restored
+/// and started again
+func WatchDaemonSwap() error {
+	var h handoff
+	json.Unmarshal([]byte(os.Getenv(HandoffEnv)), &h)
+	deadline := time.Now().Add(daemonConfirmDelay + watchdogGrace)
+	var swap *daemonSwap
+	for time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+		state, err := readState()
+		if err != nil {
+			continue
+		}
+		swap = state.DaemonSwap
+		if swap == nil { // confirmed, or restored by the daemon itself
+			return nil
+		}
+		if swap.Pid > 0 && !arch.ProcessAlive(swap.Pid) {
+			break
+		}
+	}
+	if swap == nil {
+		return fmt.Errorf("Read state file failed")
+	}
+	log.Println("iphash-daemon", swap.Version, "did not keep running, restoring previous executable")
+	if swap.Pid > 0 {
+		arch.KillTree(swap.Pid)
+	}
+	for _, pid := range []int{h.IpfsPid, h.MonitorPid} { // processes orphaned by failed daemon
+		if proc, err := os.FindProcess(pid); pid > 0 && err == nil {
+			proc.Kill()
+		}
+	}
+	exe, err := restoreDaemonSwap(swap)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe)
+	cmd.Env = environWithout(HandoffEnv, daemonMarkEnv)
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	log.Println("Previous iphash-daemon restarted")
+	return cmd.Process.Release()
+}
+
+/// Confirm swap of executable after new daemon kept running, previous executable is removed
+func confirmDaemonSwap() {
+	var swap *daemonSwap
+	err := updateState(func(state *daemonState) {
+		swap = state.DaemonSwap
+		state.DaemonSwap = nil
+	})
+	if err != nil {
+		log.Printf("[Error] Save state file failed: %#v \n", err)
+		return
+	}
+	if swap != nil {
+		os.Remove(swap.Backup)
+		log.Println("iphash-daemon", swap.Version, "confirmed")
+	}
+}
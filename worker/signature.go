@@ -0,0 +1,66 @@
+package worker
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+/// Public keys built into the daemon, comma separated base64 encoded ed25519 keys,
+/// set at build time by: -ldflags "-X iphash-daemon/worker.builtinPublicKeys=KEY1,KEY2"
+var builtinPublicKeys = ""
+
+/// Get all trusted public keys: built-in keys and configured keys, except revoked ones
+func trustedKeys() ([]ed25519.PublicKey, error) {
+	conf := getConfig()
+	revoked := make(map[string]bool)
+	for _, k := range conf.RevokedKeys {
+		revoked[strings.TrimSpace(k)] = true
+	}
+	encoded := append(strings.Split(builtinPublicKeys, ","), conf.PublicKeys...)
+	var keys []ed25519.PublicKey
+	for _, k := range encoded {
+		k = strings.TrimSpace(k)
+		if k == "" || revoked[k] {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(k)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid public key %s: %v", k, err)
+		}
+		if len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("Invalid public key %s: wrong size %d", k, len(key))
+		}
+		keys = append(keys, ed25519.PublicKey(key))
+	}
+	return keys, nil
+}
+
+/// Verify detached signature of data, signature file contains one base64 encoded ed25519
+/// signature per line, so that a file can be signed by both old and new key while rotating keys
+func verifySignature(data, sigFile []byte) error {
+	keys, err := trustedKeys()
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("No trusted public key configured")
+	}
+	for _, line := range strings.Split(string(sigFile), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(line)
+		if err != nil || len(sig) != ed25519.SignatureSize {
+			continue
+		}
+		for _, key := range keys {
+			if ed25519.Verify(key, data, sig) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("Signature verification failed")
+}
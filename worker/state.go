@@ -0,0 +1,95 @@
+package worker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+)
+
+const stateFileName = "state.json"
+
+/// Persistent state of daemon, kept between restarts
+type daemonState struct {
+	BadVersions []string `json:"bad_versions"`  // versions failed to start, never upgrade to them again
+	LastInfoURL string   `json:"last_info_url"` // upgrade information url which worked last time
+	LastMirror  string   `json:"last_mirror"`   // package mirror which worked last time
+
+	InfoCache map[string]*infoCache `json:"info_cache,omitempty"` // upgrade information fetched last time of each url
+	NodeID    string                `json:"node_id"`              // generated identity of this node
+	Hold      string                `json:"hold"`                 // version held by hold command
+
+	DaemonSwap        *daemonSwap `json:"daemon_swap,omitempty"` // executable swap not confirmed yet
+	BadDaemonVersions []string    `json:"bad_daemon_versions"`   // daemon versions failed to keep running
+}
+
+var stateLock sync.Mutex
+
+/// Load state file, empty state is returned if state file does not exist
+func loadState() (*daemonState, error) {
+	state := &daemonState{}
+	exist, err := pathExists(stateFileName)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return state, nil
+	}
+	data, err := ioutil.ReadFile(stateFileName)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+/// Read state file
+func readState() (*daemonState, error) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	return loadState()
+}
+
+/// Modify state and save it to disk
+func updateState(modify func(state *daemonState)) error {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	state, err := loadState()
+	if err != nil {
+		return err
+	}
+	modify(state)
+	data, err := json.MarshalIndent(state, "", "      ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(stateFileName, data, 0666)
+}
+
+/// Mark version as bad
+func markBadVersion(version string) error {
+	return updateState(func(state *daemonState) {
+		for _, v := range state.BadVersions {
+			if v == version {
+				return
+			}
+		}
+		state.BadVersions = append(state.BadVersions, version)
+	})
+}
+
+/// Check whether version has been marked as bad
+func isBadVersion(version string) (bool, error) {
+	state, err := readState()
+	if err != nil {
+		return false, err
+	}
+	for _, v := range state.BadVersions {
+		if v == version {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,72 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+)
+
+const statusFileName = "status.json"
+
+/// Status of running daemon, saved to status file for status command
+type daemonStatus struct {
+	Version          string    `json:"version"`
+	AvailableVersion string    `json:"available_version"`
+	PendingVersion   string    `json:"pending_version"`
+	Hold             string    `json:"hold"`
+	Channel          string    `json:"channel"`
+	LastCheck        time.Time `json:"last_check"`
+	NextCheck        time.Time `json:"next_check"`
+	Failures         int       `json:"failures"`
+	LastError        string    `json:"last_error"`
+
+	Integrity          string    `json:"integrity"`
+	LastIntegrityCheck time.Time `json:"last_integrity_check"`
+}
+
+var (
+	statusLock    sync.Mutex
+	currentStatus daemonStatus
+)
+
+/// Modify status of daemon and save it to status file
+func updateStatus(modify func(status *daemonStatus)) {
+	statusLock.Lock()
+	defer statusLock.Unlock()
+	modify(&currentStatus)
+	data, err := json.MarshalIndent(currentStatus, "", "      ")
+	if err == nil {
+		err = ioutil.WriteFile(statusFileName, data, 0666)
+	}
+	if err != nil {
+		log.Printf("[Error] Save status file failed: %#v \n", err)
+	}
+}
+
+/// Print status of running daemon
+func PrintStatus() error {
+	data, err := ioutil.ReadFile(statusFileName)
+	if err != nil {
+		return err
+	}
+	var status daemonStatus
+	err = json.Unmarshal(data, &status)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Version:          ", status.Version)
+	fmt.Println("Available version:", status.AvailableVersion)
+	fmt.Println("Pending version:  ", status.PendingVersion)
+	fmt.Println("Hold:             ", status.Hold)
+	fmt.Println("Channel:          ", status.Channel)
+	fmt.Println("Last check:       ", status.LastCheck.Format(time.RFC3339))
+	fmt.Println("Next check:       ", status.NextCheck.Format(time.RFC3339))
+	fmt.Println("Failures:         ", status.Failures)
+	fmt.Println("Last error:       ", status.LastError)
+	fmt.Println("Integrity:        ", status.Integrity)
+	fmt.Println("Integrity check:  ", status.LastIntegrityCheck.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+var errOutsideWindow = errors.New("Outside of download window")
+
+/// Reader limiting read speed of package downloads, and stopping when download window is closed
+type throttledReader struct {
+	r       io.Reader
+	rate    int64 // bytes per second, 0 for unlimited
+	windows []string
+	start   time.Time
+	read    int64
+}
+
+func newThrottledReader(r io.Reader) *throttledReader {
+	conf := getConfig()
+	return &throttledReader{r: r, rate: conf.DownloadRateLimit * 1024, windows: conf.DownloadWindows, start: time.Now()}
+}
+
+func (this *throttledReader) Read(p []byte) (int, error) {
+	open, err := inWindows(this.windows, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	if !open {
+		return 0, errOutsideWindow
+	}
+	if this.rate > 0 && int64(len(p)) > this.rate {
+		p = p[:this.rate]
+	}
+	n, err := this.r.Read(p)
+	this.read += int64(n)
+	if this.rate > 0 {
+		expected := time.Duration(this.read * int64(time.Second) / this.rate)
+		if wait := expected - time.Since(this.start); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	return n, err
+}
+
+/// Check whether package may be downloaded now
+func downloadAllowed() error {
+	open, err := inWindows(getConfig().DownloadWindows, time.Now())
+	if err != nil {
+		return err
+	}
+	if !open {
+		return errOutsideWindow
+	}
+	return nil
+}
@@ -1,28 +1,29 @@
 package worker
 
 import (
-	"archive/tar"
-	"compress/gzip"
-	"crypto/sha1"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
-	"iphash-daemon/arch"
 	"log"
-	"net/http"
 	"os"
 	"runtime"
-	"strings"
+	"time"
 )
 
-const infoURL = "http://hash.iptokenmain.com/upgrade/iphash-%s-%s.json"
+const signatureExt = ".sig"
 const upgradeFileName = "upgrade.json"
 
 type upgrader struct {
-	upgradeInfo upgradeInfo
-	finish      chan upgradeInfo
+	upgradeInfo      upgradeInfo // version running, read from local upgrade information file on first check
+	running          string      // version running when check started, upgradeInfo may be loaded from file
+	finish           chan upgradeInfo
+	daemonInfo       *upgradeInfo  // new iphash-daemon found in upgrade information
+	daemonExecutable string        // downloaded and verified executable of new iphash-daemon
+	retryAfter       time.Duration // server asks to delay next check
+	failed           bool          // upgrade information or package could not be fetched
+	deferred         bool          // upgrade is put off on purpose, like outside download window or vetoed by hook
+	lastError        string        // reason of failure
+	bundle           string        // bundle in drop folder upgrade information is taken from
 }
 
 /// Download and decompress new package if found new version
@@ -32,6 +33,7 @@ func (this *upgrader) upgrade() {
 	exist, err := pathExists(upgradeFileName)
 	if err != nil {
 		log.Printf("[Error] Check local upgrade information file failed: %#v \n", err)
+		this.fail(err)
 		this.finish <- this.upgradeInfo
 		return
 	}
@@ -40,49 +42,180 @@ func (this *upgrader) upgrade() {
 			data, err := ioutil.ReadFile(upgradeFileName)
 			if err != nil {
 				log.Printf("[Error] Read local upgrade information file failed: %#v \n", err)
+				this.fail(err)
 				this.finish <- this.upgradeInfo
 				return
 			}
 			err = json.Unmarshal([]byte(data), &this.upgradeInfo)
 			if err != nil {
 				log.Printf("[Error] Unmarshal local upgrade information file to json failed: %#v \n", err)
+				this.fail(err)
 				this.finish <- this.upgradeInfo
 				return
 			}
 		}
 	}
-	newUpgradeInfo, err = getUpgradeInfo()
+	newUpgradeInfo, this.bundle, err = bundleUpgradeInfo()
+	if err != nil {
+		log.Printf("[Error] Install bundle failed: %#v \n", err)
+		this.fail(err)
+		this.finish <- this.upgradeInfo
+		return
+	}
+	if newUpgradeInfo == nil {
+		newUpgradeInfo, err = getUpgradeInfo()
+	}
+	if e, ok := err.(*retryAfterError); ok {
+		this.retryAfter = e.delay
+	}
 	if err != nil {
 		log.Printf("[Error] Get upgrade information from server failed: %#v \n", err)
+		this.fail(err)
 		this.finish <- this.upgradeInfo
 		return
 	}
+	this.prepareDaemon(newUpgradeInfo.Daemon)
+	hold, err := heldVersion()
+	if err != nil {
+		log.Printf("[Error] Read state file failed: %#v \n", err)
+		this.fail(err)
+		this.finish <- this.upgradeInfo
+		return
+	}
+	updateStatus(func(status *daemonStatus) {
+		status.AvailableVersion = newUpgradeInfo.Version
+		status.Hold = hold
+	})
+	if hold != "" && newUpgradeInfo.Version != this.upgradeInfo.Version && newUpgradeInfo.Version != hold {
+		log.Println("Upgrade is held at version", hold, ", skip switching to", newUpgradeInfo.Version)
+		this.finish <- this.upgradeInfo
+		return
+	}
+	if newUpgradeInfo.Version != this.upgradeInfo.Version && this.upgradeInfo.Version != "" {
+		cmp, err := compareVersions(newUpgradeInfo.Version, this.upgradeInfo.Version)
+		if err != nil {
+			log.Printf("[Error] Compare version failed: %#v \n", err)
+			this.fail(err)
+			this.finish <- this.upgradeInfo
+			return
+		}
+		if cmp == 0 {
+			newUpgradeInfo = &this.upgradeInfo
+		} else if cmp < 0 && !newUpgradeInfo.AllowDowngrade && !getConfig().AllowDowngrade {
+			log.Println("Refuse to downgrade from", this.upgradeInfo.Version, "to", newUpgradeInfo.Version)
+			this.rejectBundle()
+			this.finish <- this.upgradeInfo
+			return
+		}
+		if cmp != 0 && newUpgradeInfo.Rollout != nil {
+			included, err := newUpgradeInfo.Rollout.includes(newUpgradeInfo.Version)
+			if err != nil {
+				log.Printf("[Error] Check rollout of new version failed: %#v \n", err)
+				this.fail(err)
+				this.finish <- this.upgradeInfo
+				return
+			}
+			if !included {
+				log.Println("Node is not in rollout of version", newUpgradeInfo.Version, ", stay on", this.upgradeInfo.Version)
+				this.rejectBundle()
+				this.finish <- this.upgradeInfo
+				return
+			}
+		}
+	}
 	if newUpgradeInfo.Version != this.upgradeInfo.Version {
-		log.Println("Found new version of iphash package:", newUpgradeInfo.Version)
-		//download and decompress package
-		err := downloadAndDecompress(newUpgradeInfo)
+		bad, err := isBadVersion(newUpgradeInfo.Version)
 		if err != nil {
-			log.Printf("[Error] Download and decompress new package failed: %#v \n", err)
+			log.Printf("[Error] Read state file failed: %#v \n", err)
+			this.fail(err)
+			this.finish <- this.upgradeInfo
+			return
+		}
+		if bad {
+			log.Println("Version", newUpgradeInfo.Version, "has been marked as bad, skip upgrading")
+			this.rejectBundle()
 			this.finish <- this.upgradeInfo
 			return
 		}
-		//save new upgrade file to disk
-		data, err := json.MarshalIndent(newUpgradeInfo, "", "      ")
+		log.Println("Found new version of iphash package:", newUpgradeInfo.Version)
+		downloaded, err := pathExists(fmt.Sprintf("iphash-%s-%s-%s.tar.gz", runtime.GOOS, runtime.GOARCH, newUpgradeInfo.Version))
 		if err != nil {
-			log.Printf("[Error] Marshal new upgrade information failed: %#v \n", err)
+			log.Printf("[Error] Check package of new version failed: %#v \n", err)
+			this.fail(err)
 			this.finish <- this.upgradeInfo
 			return
 		}
-		err = ioutil.WriteFile(upgradeFileName, data, 0666)
+		if !downloaded {
+			err = runHook(hookPreDownload, this.upgradeInfo.Version, newUpgradeInfo.Version)
+			if err != nil {
+				log.Printf("[Error] Upgrade to %s is vetoed: %v \n", newUpgradeInfo.Version, err)
+				this.deferred = true
+				this.lastError = err.Error()
+				this.finish <- this.upgradeInfo
+				return
+			}
+		}
+		//download and decompress package
+		err = downloadAndDecompress(newUpgradeInfo)
 		if err != nil {
-			log.Printf("[Error] Save new upgrade information to disk failed: %#v \n", err)
+			log.Printf("[Error] Download and decompress new package failed: %#v \n", err)
+			this.fail(err)
 			this.finish <- this.upgradeInfo
 			return
 		}
 	}
+	if newUpgradeInfo.Version == this.upgradeInfo.Version && this.bundle != "" { // version of bundle is running
+		log.Println("Bundle", this.bundle, "has been installed")
+		os.Remove(this.bundle)
+	}
 	this.finish <- *newUpgradeInfo
 }
 
+/// Reject bundle upgrade information is taken from, if any
+func (this *upgrader) rejectBundle() {
+	if this.bundle != "" {
+		rejectBundle(this.bundle)
+	}
+}
+
+/// Record failure of this check, closed download window is not a failure
+func (this *upgrader) fail(err error) {
+	this.lastError = err.Error()
+	if err == errOutsideWindow {
+		this.deferred = true
+		return
+	}
+	this.failed = true
+}
+
+/// Download new iphash-daemon if upgrade information describes a newer one
+func (this *upgrader) prepareDaemon(daemonInfo *upgradeInfo) {
+	need, err := needSelfUpdate(daemonInfo)
+	if err != nil {
+		log.Printf("[Error] Check new iphash-daemon failed: %#v \n", err)
+		return
+	}
+	if !need {
+		return
+	}
+	log.Println("Found new version of iphash-daemon:", daemonInfo.Version)
+	this.daemonExecutable, err = prepareSelfUpdate(daemonInfo)
+	if err != nil {
+		log.Printf("[Error] Prepare new iphash-daemon failed: %#v \n", err)
+		return
+	}
+	this.daemonInfo = daemonInfo
+}
+
+/// Save upgrade information to local upgrade information file
+func saveUpgradeInfo(upgradeInfo *upgradeInfo) error {
+	data, err := json.MarshalIndent(upgradeInfo, "", "      ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(upgradeFileName, data, 0666)
+}
+
 /// Check if file exists
 func pathExists(path string) (bool, error) {
 	_, err := os.Stat(path)
@@ -95,22 +228,91 @@ func pathExists(path string) (bool, error) {
 	return false, err
 }
 
-/// Get upgrade information
+/// Get upgrade information from configured urls in order until one succeeds,
+/// if servers ask to retry later, the longest delay is returned in error
 func getUpgradeInfo() (*upgradeInfo, error) {
-	url := fmt.Sprintf(infoURL, runtime.GOOS, runtime.GOARCH)
-	resp, err := http.Get(url)
+	urls := infoURLs()
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("No upgrade information url configured")
+	}
+	var err error
+	var retryErr *retryAfterError
+	for _, url := range urls {
+		var result *upgradeInfo
+		result, err = fetchUpgradeInfo(url)
+		if err == nil {
+			if e := updateState(func(state *daemonState) { state.LastInfoURL = url }); e != nil {
+				log.Printf("[Error] Save state file failed: %#v \n", e)
+			}
+			return result, nil
+		}
+		if e, ok := err.(*retryAfterError); ok && (retryErr == nil || e.delay > retryErr.delay) {
+			retryErr = e
+		}
+		log.Printf("[Error] Get upgrade information from %s failed: %#v \n", url, err)
+	}
+	if retryErr != nil {
+		return nil, retryErr
+	}
+	return nil, err
+}
+
+/// Get upgrade information from url, information file must be signed by a trusted key,
+/// cached information is used if server reports it is not modified
+func fetchUpgradeInfo(url string) (*upgradeInfo, error) {
+	state, err := readState()
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Get upgrade information failed: %s", resp.Status)
+	cache := state.InfoCache[url]
+	data, newCache, err := httpGetConditional(url, cache)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil { // not modified
+		return parseSignedUpgradeInfo(cache.Data, cache.Sig)
+	}
+	sig, _, err := httpGetConditional(url+signatureExt, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Get signature of upgrade information failed: %v", err)
+	}
+	result, err := parseSignedUpgradeInfo(data, sig)
+	if err != nil {
+		return nil, err
+	}
+	newCache.Sig = sig
+	err = updateState(func(state *daemonState) {
+		if state.InfoCache == nil {
+			state.InfoCache = make(map[string]*infoCache)
+		}
+		state.InfoCache[url] = newCache
+	})
+	if err != nil {
+		log.Printf("[Error] Save state file failed: %#v \n", err)
+	}
+	return result, nil
+}
+
+/// Verify signature of upgrade information and parse it
+func parseSignedUpgradeInfo(data, sig []byte) (*upgradeInfo, error) {
+	err := verifySignature(data, sig)
+	if err != nil {
+		return nil, err
 	}
 	var result upgradeInfo
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := json.Unmarshal(data, &result); err != nil {
 		return nil, err
 	}
-	return &result, nil
+	return result.forChannel(getConfig().Channel), nil
+}
+
+/// Select upgrade information of release channel from combined file,
+/// file without entry of the channel is taken as upgrade information of the channel itself
+func (this *upgradeInfo) forChannel(channel string) *upgradeInfo {
+	if info, ok := this.Channels[channel]; ok && info != nil {
+		return info
+	}
+	return this
 }
 
 func downloadAndDecompress(upgradeInfo *upgradeInfo) error {
@@ -121,14 +323,14 @@ func downloadAndDecompress(upgradeInfo *upgradeInfo) error {
 	}
 	needDownload := true
 	if ret {
-		sha1Digest, err := sha1File(packageName)
+		match, err := verifyPackage(packageName, upgradeInfo)
 		if err != nil {
 			return err
 		}
-		if sha1Digest == upgradeInfo.SHA1 {
+		if match {
 			needDownload = false
 		} else {
-			log.Println("SHA1 digest differ from upgrade information, delete package", packageName)
+			log.Println("Digest differ from upgrade information, delete package", packageName)
 			err = os.Remove(packageName)
 			if err != nil {
 				return err
@@ -136,21 +338,38 @@ func downloadAndDecompress(upgradeInfo *upgradeInfo) error {
 		}
 	}
 	if needDownload { //download new package
-		log.Println("Downloading new package", packageName, "...")
-		f, err := os.Create(packageName)
+		_, _, err := upgradeInfo.digest()
 		if err != nil {
 			return err
 		}
-		defer f.Close()
-		res, err := http.Get(upgradeInfo.URL)
+		err = downloadAllowed()
 		if err != nil {
 			return err
 		}
-		defer res.Body.Close()
-		_, err = io.Copy(f, res.Body)
+		err = preflightDownload(upgradeInfo, packageName)
 		if err != nil {
 			return err
 		}
+		err = patchPackage(upgradeInfo, packageName)
+		if err != nil {
+			if len(upgradeInfo.Patches) > 0 {
+				log.Printf("[Error] Patch previous package failed, download full package instead: %#v \n", err)
+			}
+			if upgradeInfo.CID != "" {
+				log.Println("Fetching new package", packageName, "through ipfs ...")
+				err = fetchPackageFromIPFS(upgradeInfo, packageName)
+				if err != nil {
+					log.Printf("[Error] Fetch package through ipfs failed, download from url instead: %#v \n", err)
+				}
+			}
+		}
+		if err != nil {
+			log.Println("Downloading new package", packageName, "...")
+			err = downloadPackage(upgradeInfo, packageName)
+			if err != nil {
+				return err
+			}
+		}
 		log.Println("New package", packageName, "has been downloaded")
 	}
 
@@ -161,13 +380,11 @@ func downloadAndDecompress(upgradeInfo *upgradeInfo) error {
 	}
 	needDecompress := true
 	if ret {
-		ret1, _ := pathExists(folderName + string(os.PathSeparator) + "ipfs-monitor" + arch.ExtExecution())
-		ret2, _ := pathExists(folderName + string(os.PathSeparator) + "ipfs" + arch.ExtExecution())
-		ret3, _ := pathExists(folderName + string(os.PathSeparator) + "install" + arch.ExtScript())
-		if ret1 && ret2 && ret3 {
+		err = verifyFolder(folderName)
+		if err == nil {
 			needDecompress = false
 		} else {
-			log.Println("Decompressed files are not completed, remove decompressed folder", folderName)
+			log.Println("Decompressed files are not completed or modified, remove decompressed folder", folderName, ":", err)
 			err = os.RemoveAll(folderName)
 			if err != nil {
 				return err
@@ -175,71 +392,20 @@ func downloadAndDecompress(upgradeInfo *upgradeInfo) error {
 		}
 	}
 	if needDecompress { // Decompress package
-		log.Println("Decompressing package", packageName)
-		err = deCompress(packageName, "."+string(os.PathSeparator))
+		err = preflightDecompress(upgradeInfo)
 		if err != nil {
 			return err
 		}
-		os.Chmod(folderName+string(os.PathSeparator)+"ipfs-monitor"+arch.ExtExecution(), 0755)
-		os.Chmod(folderName+string(os.PathSeparator)+"ipfs"+arch.ExtExecution(), 0755)
-		os.Chmod(folderName+string(os.PathSeparator)+"install"+arch.ExtScript(), 0755)
-		log.Println("Package", packageName, "has been decompressed")
-	}
-	return nil
-}
-
-func sha1File(fileName string) (string, error) {
-	f, err := os.Open(fileName)
-	if err != nil {
-		return "", err
-	}
-	h := sha1.New()
-	_, err = io.Copy(h, f)
-	if err != nil {
-		return "", err
-	}
-	return hex.EncodeToString(h.Sum(nil)), nil
-}
-
-func deCompress(tarFile, dest string) error {
-	srcFile, err := os.Open(tarFile)
-	if err != nil {
-		return err
-	}
-	defer srcFile.Close()
-	gr, err := gzip.NewReader(srcFile)
-	if err != nil {
-		return err
-	}
-	defer gr.Close()
-	tr := tar.NewReader(gr)
-	for {
-		hdr, err := tr.Next()
-		if err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				return err
-			}
-		}
-		if strings.HasSuffix(hdr.Name, "/") {
-			continue
+		log.Println("Decompressing package", packageName)
+		err = deCompress(packageName, "."+string(os.PathSeparator), folderName)
+		if err == nil {
+			err = verifyFolder(folderName)
 		}
-		filename := dest + hdr.Name
-		file, err := createFile(filename)
 		if err != nil {
+			os.RemoveAll(folderName)
 			return err
 		}
-		defer file.Close()
-		io.Copy(file, tr)
+		log.Println("Package", packageName, "has been decompressed")
 	}
 	return nil
 }
-
-func createFile(name string) (*os.File, error) {
-	err := os.MkdirAll(string([]rune(name)[0:strings.LastIndex(name, "/")]), 0755)
-	if err != nil {
-		return nil, err
-	}
-	return os.Create(name)
-}
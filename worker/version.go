@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/// Parsed version number, both semantic version like v1.2.3-beta.1 and
+/// short version like v0.01 are accepted, missing numbers are taken as 0
+type version struct {
+	numbers    [3]int
+	preRelease []string
+}
+
+func parseVersion(s string) (*version, error) {
+	v := &version{}
+	str := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.Index(str, "+"); i >= 0 { // build metadata is ignored
+		str = str[:i]
+	}
+	if i := strings.Index(str, "-"); i >= 0 {
+		v.preRelease = strings.Split(str[i+1:], ".")
+		str = str[:i]
+	}
+	parts := strings.Split(str, ".")
+	if str == "" || len(parts) > len(v.numbers) {
+		return nil, fmt.Errorf("Invalid version: %s", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("Invalid version: %s", s)
+		}
+		v.numbers[i] = n
+	}
+	return v, nil
+}
+
+/// Compare with another version, returns -1, 0 or 1
+func (this *version) compare(other *version) int {
+	for i := range this.numbers {
+		if this.numbers[i] != other.numbers[i] {
+			return compareInt(this.numbers[i], other.numbers[i])
+		}
+	}
+	// version without pre-release has higher precedence
+	if len(this.preRelease) == 0 || len(other.preRelease) == 0 {
+		return compareInt(len(other.preRelease), len(this.preRelease))
+	}
+	for i := 0; i < len(this.preRelease) && i < len(other.preRelease); i++ {
+		a, b := this.preRelease[i], other.preRelease[i]
+		if a == b {
+			continue
+		}
+		na, errA := strconv.Atoi(a)
+		nb, errB := strconv.Atoi(b)
+		switch {
+		case errA == nil && errB == nil:
+			return compareInt(na, nb)
+		case errA == nil: // numeric identifiers have lower precedence
+			return -1
+		case errB == nil:
+			return 1
+		default:
+			return strings.Compare(a, b)
+		}
+	}
+	return compareInt(len(this.preRelease), len(other.preRelease))
+}
+
+func compareInt(a, b int) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+/// Compare two version strings, returns -1, 0 or 1
+func compareVersions(a, b string) (int, error) {
+	va, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	return va.compare(vb), nil
+}
@@ -0,0 +1,40 @@
+package worker
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v0.01", "v0.02", -1},
+		{"v0.02", "v0.01", 1},
+		{"v0.01", "v0.1", 0},
+		{"v0.10", "v0.9", 1},
+		{"v1.2.3", "v1.2.3", 0},
+		{"v1.2.3", "v1.10.0", -1},
+		{"v2", "v1.9.9", 1},
+		{"v1.0.0-alpha", "v1.0.0", -1},
+		{"v1.0.0-alpha", "v1.0.0-alpha.1", -1},
+		{"v1.0.0-alpha.1", "v1.0.0-alpha.beta", -1},
+		{"v1.0.0-beta.2", "v1.0.0-beta.11", -1},
+		{"v1.0.0-rc.1", "v1.0.0-beta.11", 1},
+		{"v1.0.0+build.1", "v1.0.0", 0},
+	}
+	for _, test := range tests {
+		got, err := compareVersions(test.a, test.b)
+		if err != nil {
+			t.Errorf("compareVersions(%q, %q) failed: %v", test.a, test.b, err)
+		} else if got != test.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestCompareVersionsInvalid(t *testing.T) {
+	for _, s := range []string{"", "v", "latest", "v1.2.3.4", "v1.x", "v-1"} {
+		if _, err := compareVersions(s, "v0.01"); err == nil {
+			t.Errorf("compareVersions(%q, v0.01) succeeds", s)
+		}
+	}
+}
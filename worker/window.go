@@ -0,0 +1,98 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+/// Time window in local time like "22:00-06:00" or "Mon-Fri 01:00-05:30" or "Sat,Sun 00:00-24:00",
+/// window crossing midnight belongs to the weekday it starts
+type timeWindow struct {
+	days  [7]bool
+	start int // minutes from midnight
+	end   int
+}
+
+func parseWindow(s string) (*timeWindow, error) {
+	window := &timeWindow{}
+	fields := strings.Fields(s)
+	switch len(fields) {
+	case 1:
+		for i := range window.days {
+			window.days[i] = true
+		}
+	case 2:
+		for _, part := range strings.Split(strings.ToLower(fields[0]), ",") {
+			bounds := strings.Split(part, "-")
+			if len(bounds) > 2 {
+				return nil, fmt.Errorf("Invalid weekdays in time window: %s", s)
+			}
+			first, last := weekdayIndex(bounds[0]), weekdayIndex(bounds[len(bounds)-1])
+			if first < 0 || last < 0 {
+				return nil, fmt.Errorf("Invalid weekdays in time window: %s", s)
+			}
+			for d := first; ; d = (d + 1) % 7 {
+				window.days[d] = true
+				if d == last {
+					break
+				}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("Invalid time window: %s", s)
+	}
+	var h1, m1, h2, m2 int
+	_, err := fmt.Sscanf(fields[len(fields)-1], "%d:%d-%d:%d", &h1, &m1, &h2, &m2)
+	// only end may be 24:00
+	if err != nil || h1 < 0 || h1 > 23 || h2 < 0 || h2 > 24 || m1 < 0 || m1 > 59 || m2 < 0 || m2 > 59 || (h2 == 24 && m2 != 0) {
+		return nil, fmt.Errorf("Invalid hours in time window: %s", s)
+	}
+	window.start = h1*60 + m1
+	window.end = h2*60 + m2
+	if window.start == window.end {
+		return nil, fmt.Errorf("Empty time window: %s", s)
+	}
+	return window, nil
+}
+
+func weekdayIndex(name string) int {
+	for i, n := range weekdayNames {
+		if strings.HasPrefix(name, n) {
+			return i
+		}
+	}
+	return -1
+}
+
+/// Check whether time is inside window
+func (this *timeWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	weekday := int(t.Weekday())
+	if this.start <= this.end {
+		return this.days[weekday] && minute >= this.start && minute < this.end
+	}
+	if minute >= this.start {
+		return this.days[weekday]
+	}
+	return minute < this.end && this.days[(weekday+6)%7]
+}
+
+/// Check whether time is inside any of windows, no window means always
+func inWindows(windows []string, t time.Time) (bool, error) {
+	if len(windows) == 0 {
+		return true, nil
+	}
+	for _, s := range windows {
+		window, err := parseWindow(s)
+		if err != nil {
+			return false, err
+		}
+		if window.contains(t) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,78 @@
+package worker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		window     string
+		start, end int
+		days       string // weekdays from Sunday, x for included
+	}{
+		{"22:00-06:00", 22 * 60, 6 * 60, "xxxxxxx"},
+		{"00:00-24:00", 0, 24 * 60, "xxxxxxx"},
+		{"Mon-Fri 01:00-05:30", 60, 5*60 + 30, ".xxxxx."},
+		{"Sat,Sun 00:00-24:00", 0, 24 * 60, "x.....x"},
+		{"fri-mon 23:00-01:00", 23 * 60, 60, "xx...xx"},
+	}
+	for _, test := range tests {
+		window, err := parseWindow(test.window)
+		if err != nil {
+			t.Errorf("parseWindow(%q) failed: %v", test.window, err)
+			continue
+		}
+		days := ""
+		for _, included := range window.days {
+			if included {
+				days += "x"
+			} else {
+				days += "."
+			}
+		}
+		if window.start != test.start || window.end != test.end || days != test.days {
+			t.Errorf("parseWindow(%q) = %d-%d %s, want %d-%d %s", test.window, window.start, window.end, days, test.start, test.end, test.days)
+		}
+	}
+}
+
+func TestParseWindowInvalid(t *testing.T) {
+	for _, s := range []string{"", "22:00", "00:00-24:30", "24:00-06:00", "10:00-10:00", "25:00-06:00", "01:60-02:00", "Foo 01:00-02:00", "Mon-Tue-Wed 01:00-02:00", "Mon Tue 01:00-02:00"} {
+		if _, err := parseWindow(s); err == nil {
+			t.Errorf("parseWindow(%q) succeeds", s)
+		}
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	// 2026-10-16 is a Friday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, time.Local)
+	}
+	tests := []struct {
+		window string
+		t      time.Time
+		want   bool
+	}{
+		{"00:00-24:00", at(16, 0, 0), true},
+		{"00:00-24:00", at(16, 23, 59), true},
+		{"01:00-05:30", at(16, 5, 30), false},
+		{"Fri 22:00-06:00", at(16, 23, 0), true},
+		{"Fri 22:00-06:00", at(17, 3, 0), true},   // Saturday morning belongs to Friday window
+		{"Fri 22:00-06:00", at(17, 23, 0), false}, // Saturday night
+		{"Fri 22:00-06:00", at(16, 3, 0), false},  // Friday morning belongs to Thursday window
+		{"Fri 22:00-06:00", at(17, 6, 0), false},
+		{"Sat,Sun 00:00-24:00", at(18, 12, 0), true},
+		{"Sat,Sun 00:00-24:00", at(19, 0, 0), false},
+	}
+	for _, test := range tests {
+		window, err := parseWindow(test.window)
+		if err != nil {
+			t.Fatalf("parseWindow(%q) failed: %v", test.window, err)
+		}
+		if got := window.contains(test.t); got != test.want {
+			t.Errorf("%q contains %s = %v, want %v", test.window, test.t.Format("Mon 15:04"), got, test.want)
+		}
+	}
+}
@@ -2,46 +2,266 @@ package worker
 
 import (
 	"log"
+	"math/rand"
 	"time"
 )
 
 type upgradeInfo struct {
-	Version string `json:"version"`
-	URL     string `json:"url"`
-	SHA1    string `json:"sha1"`
+	Version string            `json:"version"`
+	URL     string            `json:"url"`
+	SHA1    string            `json:"sha1,omitempty"`
+	SHA256  string            `json:"sha256,omitempty"`
+	SHA512  string            `json:"sha512,omitempty"`
+	Digests map[string]string `json:"digests,omitempty"`
+
+	AllowDowngrade bool     `json:"allow_downgrade,omitempty"` // this version is intended to replace a newer one
+	Rollout        *rollout `json:"rollout,omitempty"`         // only nodes in rollout upgrade to this version, all nodes if empty
+
+	Channels map[string]*upgradeInfo `json:"channels,omitempty"` // upgrade information of each release channel in combined file
+	Patches  []patchInfo             `json:"patches,omitempty"`  // patches from previous versions to this version
+	Urgent   bool                    `json:"urgent,omitempty"`   // apply without waiting for maintenance window
+	Daemon   *upgradeInfo            `json:"daemon,omitempty"`   // new build of iphash-daemon itself
+	CID      string                  `json:"cid,omitempty"`      // CID of package for fetching through local ipfs node
+
+	Size         int64 `json:"size,omitempty"`          // bytes of package
+	UnpackedSize int64 `json:"unpacked_size,omitempty"` // bytes of package after decompressing
+	FileCount    int64 `json:"file_count,omitempty"`    // number of files in package
+}
+
+func newProcManager(upgradeInfo upgradeInfo) *procManager {
+	return &procManager{upgradeInfo: upgradeInfo, stopping: false, monitorSig: make(chan struct{}), ipfsSig: make(chan struct{})}
+}
+
+/// Stop failed version, mark it as bad and boot previous version again
+func rollback(failed *procManager, previousInfo upgradeInfo) *procManager {
+	log.Println("Version", failed.upgradeInfo.Version, "failed to become healthy, rolling back to", previousInfo.Version)
+	failed.stop()
+	err := markBadVersion(failed.upgradeInfo.Version)
+	if err != nil {
+		log.Printf("[Error] Mark bad version failed: %#v \n", err)
+	}
+	err = downloadAndDecompress(&previousInfo)
+	if err != nil {
+		log.Printf("[Error] Check package of previous version failed: %#v \n", err)
+	}
+	err = saveUpgradeInfo(&previousInfo)
+	if err != nil {
+		log.Printf("[Error] Save upgrade information of previous version failed: %#v \n", err)
+	}
+	pManager := newProcManager(previousInfo)
+	err = pManager.boot()
+	if err != nil {
+		log.Printf("[Error] Previous version %s started failed after rolling back: %#v \n", previousInfo.Version, err)
+	}
+	err = runHook(hookOnRollback, failed.upgradeInfo.Version, previousInfo.Version)
+	if err != nil {
+		log.Printf("[Error] %v \n", err)
+	}
+	return pManager
+}
+
+/// Get channel fired when next integrity check is due, nil channel if integrity check is disabled
+func integrityAfter() <-chan time.Time {
+	minutes := getConfig().IntegrityInterval
+	if minutes <= 0 {
+		return nil
+	}
+	return time.After(time.Minute * time.Duration(minutes))
 }
 
 type Main struct {
 	Stop chan struct{}
 	Done chan struct{}
+
+	versionInfo upgradeInfo  // version running now
+	pManager    *procManager // processes of running version
+	pending     *upgradeInfo // downloaded version waiting for maintenance window
+	switchAt    time.Time    // time to switch to pending version, chosen randomly inside maintenance window
+	failures    int          // consecutive failed checks
+	bundle      string       // bundle in drop folder last found
 }
 
 func (this *Main) Start() {
-	var versionInfo upgradeInfo
-	var pManager *procManager
+	err := ReloadConfig()
+	if err != nil {
+		log.Printf("[Error] Load configuration file failed: %#v \n", err)
+	}
+	checkDaemonSwap()
+	this.adopt()
 	stop := false
-	interval := time.Second * 1
+	rand.Seed(time.Now().UnixNano())
+	checkTimer := time.After(firstCheckDelay())
+	dropTimer := time.After(dropInterval)
+	integrityTimer := integrityAfter()
+	var switchTimer <-chan time.Time
+	confirmTimer := time.After(daemonConfirmDelay)
+	var checking *upgrader             // check running in background, a slow download does not block the loop
+	var finish <-chan upgradeInfo      // result of running check, nil if no check is running
+	var scanned <-chan integrityResult // result of running integrity check, nil if no integrity check is running
 	for !stop {
 		select {
 		case <-this.Stop: //graceful stop all processes
 			log.Println("Stopping iphash-daemon...")
 			stop = true
-			pManager.stop()
+			if this.pManager != nil {
+				this.pManager.stop()
+			}
 			this.Done <- struct{}{}
-		case <-time.After(interval): //call upgrader to check and download new package of iphash
-			interval = time.Minute * 10
-			finish := make(chan upgradeInfo)
-			upgrader := &upgrader{upgradeInfo: versionInfo, finish: finish}
-			go upgrader.upgrade()
-			newVersionInfo := <-finish
-			if newVersionInfo.Version != versionInfo.Version { //Package has upgraded， stop exist progresses and execute new version package
-				if pManager != nil {
-					pManager.stop()
+		case <-integrityTimer: //check installed files of running version
+			integrityTimer = nil
+			if this.pManager != nil {
+				scanned = scanIntegrity(this.pManager.upgradeInfo)
+			} else {
+				integrityTimer = integrityAfter()
+			}
+		case result := <-scanned: //integrity check has finished
+			scanned = nil
+			this.integrityChecked(result)
+			integrityTimer = integrityAfter()
+		case <-dropTimer: //check immediately when a bundle is dropped for offline installation
+			dropTimer = time.After(dropInterval)
+			bundleName, err := findBundle()
+			if err != nil {
+				log.Printf("[Error] Check drop folder failed: %#v \n", err)
+			} else if bundleName != "" && bundleName != this.bundle && checking == nil { // bundle kept for later checks is not new
+				this.bundle = bundleName
+				checkTimer = time.After(0)
+			}
+		case <-confirmTimer: //new daemon has kept running after self-update
+			confirmDaemonSwap()
+		case <-switchTimer: //switch to pending version if maintenance window is open
+			switchTimer = this.trySwitch()
+		case <-checkTimer: //call upgrader to check and download new package of iphash
+			checkTimer = nil
+			checking = &upgrader{upgradeInfo: this.versionInfo, running: this.versionInfo.Version, finish: make(chan upgradeInfo, 1)}
+			finish = checking.finish
+			go checking.upgrade()
+		case newVersionInfo := <-finish: //check has finished
+			upgrader := checking
+			checking, finish = nil, nil
+			if upgrader.failed {
+				this.failures++
+			} else {
+				this.failures = 0
+			}
+			delay := nextCheckDelay(this.failures, upgrader.retryAfter)
+			checkTimer = time.After(delay)
+			nextCheck := time.Now().Add(delay)
+			switchTimer = this.checkFinished(upgrader, newVersionInfo, switchTimer)
+			updateStatus(func(status *daemonStatus) {
+				status.Version = this.versionInfo.Version
+				status.PendingVersion = ""
+				if this.pending != nil {
+					status.PendingVersion = this.pending.Version
 				}
-				pManager = &procManager{upgradeInfo: newVersionInfo, stopping: false, monitorSig: make(chan struct{}), ipfsSig: make(chan struct{})}
-				pManager.boot()
-				versionInfo = newVersionInfo
+				status.Channel = getConfig().Channel
+				status.LastCheck = time.Now()
+				status.NextCheck = nextCheck
+				status.Failures = this.failures
+				status.LastError = upgrader.lastError
+			})
+			if integrityTimer == nil && scanned == nil { // integrity check may be enabled by reloading configuration
+				integrityTimer = integrityAfter()
 			}
+			if upgrader.daemonExecutable != "" { //new iphash-daemon has been downloaded
+				err := this.selfUpdate(upgrader.daemonInfo, upgrader.daemonExecutable)
+				log.Printf("[Error] Self-update of iphash-daemon failed: %#v \n", err)
+			}
+		}
+	}
+}
+
+/// Take version reported by finished check, returns channel fired when pending version should be tried
+func (this *Main) checkFinished(upgrader *upgrader, newVersionInfo upgradeInfo, switchTimer <-chan time.Time) <-chan time.Time {
+	if upgrader.running != this.versionInfo.Version {
+		// running version has been switched or rolled back during the check, next check decides again
+	} else if newVersionInfo.Version == this.versionInfo.Version {
+		if !upgrader.failed && !upgrader.deferred { // such check reports running version too, pending version is still valid
+			this.pending, switchTimer = nil, nil
+		}
+	} else if this.pManager == nil { // first boot, nothing to interrupt
+		this.switchVersion(newVersionInfo, upgrader.upgradeInfo)
+	} else { //Package has upgraded， wait for maintenance window to stop exist progresses and execute new version package
+		if this.pending == nil || this.pending.Version != newVersionInfo.Version {
+			this.switchAt = time.Time{}
+		}
+		this.pending = &newVersionInfo
+		switchTimer = this.trySwitch()
+	}
+	return switchTimer
+}
+
+/// Switch to pending version if allowed now, returns channel fired when it should be tried again
+func (this *Main) trySwitch() <-chan time.Time {
+	if this.pending == nil {
+		return nil
+	}
+	now := time.Now()
+	if !this.pending.Urgent {
+		open, err := inMaintenanceWindow(now)
+		if err != nil {
+			log.Printf("[Error] Check maintenance window failed: %#v \n", err)
+			return time.After(time.Minute)
+		}
+		if !open {
+			this.switchAt = time.Time{}
+			log.Println("Version", this.pending.Version, "is waiting for maintenance window")
+			return time.After(time.Minute)
+		}
+		if this.switchAt.IsZero() {
+			this.switchAt = now.Add(maintenanceSplay())
+		}
+		if now.Before(this.switchAt) {
+			return time.After(this.switchAt.Sub(now))
+		}
+	}
+	pending := *this.pending
+	this.pending = nil
+	this.switchVersion(pending, this.versionInfo)
+	updateStatus(func(status *daemonStatus) {
+		status.Version = this.versionInfo.Version
+		status.PendingVersion = ""
+	})
+	return nil
+}
+
+/// Stop processes of running version and boot new version, roll back to previous version if failed
+func (this *Main) switchVersion(newVersionInfo, previousInfo upgradeInfo) {
+	if this.versionInfo.Version == "" { // first boot, make sure installed files are not modified
+		err := downloadAndDecompress(&newVersionInfo)
+		if err != nil {
+			log.Printf("[Error] Check installed package failed: %#v \n", err)
+		}
+	}
+	if this.pManager != nil {
+		err := runHook(hookPreStop, this.versionInfo.Version, newVersionInfo.Version)
+		if err != nil {
+			log.Printf("[Error] Switching to %s is vetoed: %v \n", newVersionInfo.Version, err)
+			return
+		}
+		this.pManager.stop()
+	}
+	//save new upgrade file to disk
+	err := saveUpgradeInfo(&newVersionInfo)
+	if err != nil {
+		log.Printf("[Error] Save new upgrade information to disk failed: %#v \n", err)
+	}
+	this.pManager = newProcManager(newVersionInfo)
+	err = this.pManager.boot()
+	if err != nil && previousInfo.Version != "" && previousInfo.Version != newVersionInfo.Version {
+		this.pManager = rollback(this.pManager, previousInfo)
+		newVersionInfo = previousInfo
+	} else if err == nil {
+		err = runHook(hookPostStart, previousInfo.Version, newVersionInfo.Version)
+		if err != nil {
+			log.Printf("[Error] %v \n", err)
+		}
+		reclaimed, err := collectGarbage(newVersionInfo.Version)
+		if err != nil {
+			log.Printf("[Error] Remove old packages failed: %#v \n", err)
+		} else if reclaimed > 0 {
+			log.Println("Old packages removed,", reclaimed, "bytes reclaimed")
 		}
 	}
+	this.versionInfo = newVersionInfo
 }
@@ -0,0 +1,122 @@
+package worker
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+/// Change working directory to a temporary folder, restored and removed by returned function
+func inTempDir(t *testing.T) func() {
+	dir, remove := tempDir(t)
+	wd, err := os.Getwd()
+	if err != nil {
+		remove()
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		remove()
+		t.Fatal(err)
+	}
+	return func() {
+		os.Chdir(wd)
+		remove()
+	}
+}
+
+/// Fake ipfs and ipfs-monitor of version, daemons keep running until interrupted
+func writeFakePackage(t *testing.T, version string) {
+	folder := fmt.Sprintf("iphash-%s-%s-%s", runtime.GOOS, runtime.GOARCH, version)
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		t.Fatal(err)
+	}
+	scripts := map[string]string{
+		"ipfs":         "#!/bin/sh\nif [ \"$1\" = daemon ]; then exec sleep 60; fi\n",
+		"ipfs-monitor": "#!/bin/sh\nexec sleep 60\n",
+	}
+	for name, script := range scripts {
+		if err := ioutil.WriteFile(filepath.Join(folder, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFirstCheckBootsLocalVersionWhenServerUnreachable(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake package uses shell scripts")
+	}
+	defer inTempDir(t)()
+	defer withConfig(func(conf *config) {
+		conf.InfoURLs = []string{"http://127.0.0.1:1/info.json"}
+		conf.DropDir = ""
+		conf.RequestTimeout = 1
+		conf.ConnectTimeout = 1
+		conf.HealthTimeout = 5
+	})()
+	if err := ioutil.WriteFile(upgradeFileName, []byte(`{"version": "v0.01"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeFakePackage(t, "v0.01")
+
+	// the same steps as Main.Start takes for a check after daemon restarted
+	main := &Main{}
+	checking := &upgrader{upgradeInfo: main.versionInfo, running: main.versionInfo.Version, finish: make(chan upgradeInfo, 1)}
+	checking.upgrade()
+	newVersionInfo := <-checking.finish
+	if !checking.failed {
+		t.Fatal("check succeeds with unreachable server")
+	}
+	main.checkFinished(checking, newVersionInfo, nil)
+	if main.pManager == nil {
+		t.Fatal("local version is not booted")
+	}
+	defer main.pManager.stop()
+	if main.versionInfo.Version != "v0.01" {
+		t.Fatalf("running version = %q, want v0.01", main.versionInfo.Version)
+	}
+}
+
+func TestDowngradeRefused(t *testing.T) {
+	defer inTempDir(t)()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := []byte(`{"version": "v0.01", "url": "http://127.0.0.1:1/package.tar.gz", "sha256": "00"}`)
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, info))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/info.json":
+			w.Write(info)
+		case "/info.json.sig":
+			w.Write([]byte(sig))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	defer withConfig(func(conf *config) {
+		conf.InfoURLs = []string{server.URL + "/info.json"}
+		conf.PublicKeys = []string{base64.StdEncoding.EncodeToString(pub)}
+		conf.DropDir = ""
+	})()
+
+	current := upgradeInfo{Version: "v0.02"}
+	checking := &upgrader{upgradeInfo: current, running: current.Version, finish: make(chan upgradeInfo, 1)}
+	checking.upgrade()
+	newVersionInfo := <-checking.finish
+	if newVersionInfo.Version != "v0.02" {
+		t.Fatalf("check reports version %q, want v0.02", newVersionInfo.Version)
+	}
+	if checking.failed {
+		t.Fatalf("check fails: %s", checking.lastError)
+	}
+}